@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/i582/cfmt/cmd/cfmt"
+	"github.com/pixfid/luft/core/analysis"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Analysis flags
+	analyzeWindow time.Duration
+	analyzeExport bool
+	analyzeFormat string
+	analyzeOutput string
+)
+
+var analyzeCmd = &cobra.Command{
+	Use:   "analyze",
+	Short: "Analyze collected USB events for suspicious devices",
+	Long: `Run detection heuristics on USB device events collected from any source.
+
+Available analyses:
+  - badusb: HID injection devices (Rubber Ducky, Digispark, Teensy and similar)`,
+}
+
+var analyzeBadUSBCmd = &cobra.Command{
+	Use:   "badusb",
+	Short: "Detect BadUSB / HID injection devices",
+	Long: `Detect devices that look like keystroke injection tools.
+
+Flags:
+  - composite-storage-hid:   a storage device also exposing a keyboard or other HID interface
+  - programmable-board-hid:  a keyboard-capable HID device from a programmable board vendor
+                             (Arduino, Teensy, Digispark, Raspberry Pi Pico...)
+  - hid-vendor-not-keyboard: a keyboard-capable HID device whose vendor is unknown or lists
+                             no keyboards in usb.ids
+  - re-enumeration:          a device re-enumerating on the same port within --window with
+                             different descriptors or interface classes
+
+Examples:
+  # Analyze local logs
+  luft analyze badusb --source local
+
+  # Analyze a copied journal, flag re-enumerations within 30 seconds
+  luft analyze badusb --source journal --path /evidence/var/log/journal --window 30s
+
+  # Analyze the events database and export the findings
+  luft analyze badusb --source database --export --format json --output findings`,
+	RunE: runAnalyzeBadUSB,
+}
+
+func init() {
+	rootCmd.AddCommand(analyzeCmd)
+	analyzeCmd.AddCommand(analyzeBadUSBCmd)
+
+	addSourceFlags(analyzeBadUSBCmd)
+
+	analyzeBadUSBCmd.Flags().DurationVar(&analyzeWindow, "window", analysis.DefaultWindow, "max time between sessions on a port to count as re-enumeration")
+
+	// Export flags
+	analyzeBadUSBCmd.Flags().BoolVarP(&analyzeExport, "export", "e", false, "export findings")
+	analyzeBadUSBCmd.Flags().StringVarP(&analyzeFormat, "format", "F", "json", "export format (json, xml)")
+	analyzeBadUSBCmd.Flags().StringVarP(&analyzeOutput, "output", "o", "badusb_findings", "export filename (without extension)")
+}
+
+func runAnalyzeBadUSB(cmd *cobra.Command, args []string) error {
+	params, err := buildParams()
+	if err != nil {
+		return err
+	}
+	params.Analysis = "badusb"
+	params.AnalysisWindow = analyzeWindow
+	params.Export = analyzeExport
+	params.Format = analyzeFormat
+	params.FileName = analyzeOutput
+
+	// Heuristics need every device, ignore listing filters set in the config file
+	params.OnlyMass = false
+	params.Untrusted = false
+	params.Number = 0
+
+	// Vendor checks rely on the USB IDs database
+	if err := loadUSBIDs(); err != nil {
+		return err
+	}
+
+	if err := runSource(params); err != nil {
+		return err
+	}
+
+	_, _ = cfmt.Println(cfmt.Sprintf("[*] Completed at: %v", time.Now().Format(time.Stamp)))
+	return nil
+}
@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"github.com/i582/cfmt/cmd/cfmt"
+	"github.com/pixfid/luft/config"
+	"github.com/pixfid/luft/core/database"
 	"github.com/pixfid/luft/core/parsers"
 	"github.com/pixfid/luft/core/utils"
 	"github.com/pixfid/luft/data"
@@ -14,16 +16,24 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// defaultLogPath is the log directory read when --path is not given
+const defaultLogPath = "/var/log/"
+
 var (
 	// Source flags
-	sourceType string
-	logPath    string
-	remoteHost string
+	sourceType    string
+	logPath       string
+	logInclude    []string
+	logExclude    []string
+	dryRun        bool
+	remoteHost    string
+	referenceDate string
 
 	// Filter flags
 	massStorage bool
 	untrusted   bool
 	checkWl     bool
+	showIgnored bool
 	number      int
 	sortBy      string
 	whitelist   string
@@ -33,11 +43,17 @@ var (
 	export       bool
 	exportFormat string
 	exportFile   string
+	evidence     bool
+	explain      string
 
 	// Performance flags
 	workers   int
 	streaming bool
 
+	// Database flags
+	dbPath   string
+	saveToDB bool
+
 	// Remote flags
 	remoteIP      string
 	remotePort    string
@@ -46,6 +62,21 @@ var (
 	remoteSSHKey  string
 	remoteTimeout int
 	insecureSSH   bool
+	remoteLogPath string
+	// remotePathFlag is --path when given, the log directory of every remote host
+	remotePathFlag string
+
+	// Jump host flags
+	jumpHostSpecs   []string
+	jumpKey         string
+	configJumpHosts []config.JumpHost // jump hosts of the --remote-host config entry
+
+	// Multi-host remote flags
+	allHosts     bool
+	remoteHosts  []string
+	remoteGroups []string
+	remoteTags   []string
+	hostWorkers  int
 )
 
 var eventsCmd = &cobra.Command{
@@ -56,75 +87,175 @@ var eventsCmd = &cobra.Command{
 Supports multiple sources:
   - local:    Analyze logs from the local system
   - remote:   Analyze logs from a remote system via SSH
-  - database: Analyze logs from a database (future feature)
+  - journal:  Analyze systemd journal files (works on offline copies)
+  - database: Analyze events stored by earlier scans (see --save)
 
 Examples:
   # Analyze local logs
   luft events --source local
 
+  # Analyze a copied systemd journal directory
+  luft events --source journal --path /evidence/var/log/journal
+
+  # Analyze logs copied from an evidence disk acquired on 2024-03-01
+  luft events --source local --path /evidence/var/log --reference-date 2024-03-01
+
   # Analyze remote host from config
   luft events --source remote --remote-host prod-server
 
+  # Analyze a remote host behind a bastion
+  luft events --source remote --remote-ip 10.20.0.5 --jump-host jump@bastion.example.com
+
+  # Analyze every host of the config, or the hosts tagged finance, 16 at a time
+  luft events --source remote --all-hosts
+  luft events --source remote --tag finance --parallel-hosts 16
+
+  # Analyze the hosts of the lab group from config
+  luft events --source remote --group lab
+
+  # Store a scan in the events database, then query the history later
+  luft events --source local --save
+  luft events --source database --mass-storage --sort desc
+
   # Analyze with filters
   luft events --source local --mass-storage --untrusted --check-whitelist
 
   # Export to PDF
-  luft events --source local --export --format pdf --output report`,
+  luft events --source local --export --format pdf --output report
+
+  # Export with the log lines of every event, or print those of one event
+  luft events --source local --export --format json --evidence
+  luft events --source local --explain 3f2a9c1b`,
 	RunE: runEvents,
 }
 
 func init() {
 	rootCmd.AddCommand(eventsCmd)
 
-	// Source flags
-	eventsCmd.Flags().StringVarP(&sourceType, "source", "S", "", "event source (local, remote, database) [required]")
-	eventsCmd.Flags().StringVar(&logPath, "path", "/var/log/", "log directory path")
-	eventsCmd.Flags().StringVar(&remoteHost, "remote-host", "", "remote host name from config file")
-	eventsCmd.MarkFlagRequired("source")
+	addSourceFlags(eventsCmd)
 
 	// Filter flags
 	eventsCmd.Flags().BoolVarP(&massStorage, "mass-storage", "m", false, "show only mass storage devices")
 	eventsCmd.Flags().BoolVarP(&untrusted, "untrusted", "u", false, "show only untrusted devices")
 	eventsCmd.Flags().BoolVarP(&checkWl, "check-whitelist", "c", false, "check devices against whitelist")
+	eventsCmd.Flags().BoolVar(&showIgnored, "show-ignored", false, "show devices matched by whitelist rules marked ignore")
 	eventsCmd.Flags().IntVarP(&number, "number", "n", 0, "number of events to show (0 = all)")
 	eventsCmd.Flags().StringVarP(&sortBy, "sort", "s", "asc", "sort events (asc, desc)")
-	eventsCmd.Flags().StringVarP(&whitelist, "whitelist", "W", "", "whitelist file path")
-	eventsCmd.Flags().StringVarP(&usbidsPath, "usbids", "U", "/var/lib/usbutils/usb.ids", "USB IDs database path")
+	eventsCmd.Flags().StringVarP(&whitelist, "whitelist", "W", "", "whitelist file path (YAML, JSON, udev or USBGuard rules)")
 
 	// Export flags
 	eventsCmd.Flags().BoolVarP(&export, "export", "e", false, "export events")
 	eventsCmd.Flags().StringVarP(&exportFormat, "format", "F", "pdf", "export format (json, xml, pdf)")
 	eventsCmd.Flags().StringVarP(&exportFile, "output", "o", "events_data", "export filename (without extension)")
+	eventsCmd.Flags().BoolVar(&evidence, "evidence", false, "include the log lines each event was built from in the export")
+	eventsCmd.Flags().StringVar(&explain, "explain", "", "print the log lines the event with this ID (or ID prefix) was built from")
+
+	// Database flags
+	eventsCmd.Flags().BoolVar(&saveToDB, "save", false, "save scanned events to the events database")
+}
+
+// addSourceFlags registers the flags selecting and reading an event source, shared
+// by the commands working on collected events
+func addSourceFlags(cmd *cobra.Command) {
+	// Source flags
+	cmd.Flags().StringVarP(&sourceType, "source", "S", "", "event source (local, remote, journal, database) [required]")
+	cmd.Flags().StringVar(&logPath, "path", defaultLogPath, "log directory path (or tar/zip archive for local scans), on the remote host too when given")
+	cmd.Flags().StringSliceVar(&logInclude, "include", nil, "glob or re:regexp of the log files to parse, replacing the default ones (repeatable)")
+	cmd.Flags().StringSliceVar(&logExclude, "exclude", nil, "glob or re:regexp of the log files or directories to skip (repeatable)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "list the log files that would be parsed and why, without parsing them")
+	cmd.Flags().StringVar(&remoteHost, "remote-host", "", "remote host name from config file")
+	cmd.Flags().StringVar(&referenceDate, "reference-date", "", "date the logs were acquired (YYYY-MM-DD or RFC 3339), used to infer syslog years")
+	cmd.MarkFlagRequired("source")
+
+	cmd.Flags().StringVarP(&usbidsPath, "usbids", "U", "/var/lib/usbutils/usb.ids", "USB IDs database path")
 
 	// Performance flags
-	eventsCmd.Flags().IntVarP(&workers, "workers", "w", 0, "number of worker threads (0 = auto)")
-	eventsCmd.Flags().BoolVar(&streaming, "streaming", false, "use streaming parser for large logs")
+	cmd.Flags().IntVarP(&workers, "workers", "w", 0, "number of worker threads (0 = auto)")
+	cmd.Flags().BoolVar(&streaming, "streaming", false, "use streaming parser for large logs")
+
+	// Database flags
+	cmd.Flags().StringVar(&dbPath, "db", database.DefaultPath, "events database path")
 
 	// Remote flags
-	eventsCmd.Flags().StringVarP(&remoteIP, "remote-ip", "I", "", "remote host IP address")
-	eventsCmd.Flags().StringVar(&remotePort, "remote-port", "22", "remote SSH port")
-	eventsCmd.Flags().StringVarP(&remoteLogin, "remote-login", "L", "", "remote login username")
-	eventsCmd.Flags().StringVarP(&remotePass, "remote-password", "P", "", "remote password (deprecated, use SSH key)")
-	eventsCmd.Flags().StringVarP(&remoteSSHKey, "remote-key", "K", "", "path to SSH private key (recommended)")
-	eventsCmd.Flags().IntVarP(&remoteTimeout, "remote-timeout", "T", 30, "SSH connection timeout in seconds")
-	eventsCmd.Flags().BoolVar(&insecureSSH, "insecure-ssh", false, "skip SSH host key verification (NOT RECOMMENDED)")
+	cmd.Flags().StringVarP(&remoteIP, "remote-ip", "I", "", "remote host IP address or ~/.ssh/config Host alias")
+	cmd.Flags().StringVar(&remotePort, "remote-port", "22", "remote SSH port")
+	cmd.Flags().StringVarP(&remoteLogin, "remote-login", "L", "", "remote login username")
+	cmd.Flags().StringVarP(&remotePass, "remote-password", "P", "", "remote password (deprecated, use SSH key)")
+	cmd.Flags().StringVarP(&remoteSSHKey, "remote-key", "K", "", "path to SSH private key (recommended)")
+	cmd.Flags().IntVarP(&remoteTimeout, "remote-timeout", "T", 30, "SSH connection timeout in seconds")
+	cmd.Flags().BoolVar(&insecureSSH, "insecure-ssh", false, "skip SSH host key verification (NOT RECOMMENDED)")
+	cmd.Flags().StringSliceVarP(&jumpHostSpecs, "jump-host", "J", nil, "jump host [user@]host[:port] to connect through (repeatable, in order)")
+	cmd.Flags().StringVar(&jumpKey, "jump-key", "", "path to SSH private key for the jump hosts")
+
+	// Multi-host remote flags
+	cmd.Flags().BoolVar(&allHosts, "all-hosts", false, "scan every remote host from config file")
+	cmd.Flags().StringSliceVar(&remoteHosts, "hosts", nil, "remote host names from config file, comma separated")
+	cmd.Flags().StringSliceVar(&remoteGroups, "group", nil, "scan the remote hosts from config file in this group (repeatable)")
+	cmd.Flags().StringSliceVar(&remoteTags, "tag", nil, "scan the remote hosts from config file with this tag (repeatable)")
+	cmd.Flags().IntVar(&hostWorkers, "parallel-hosts", parsers.DefaultHostWorkers, "remote hosts scanned at once")
 }
 
 func runEvents(cmd *cobra.Command, args []string) error {
+	params, err := buildParams()
+	if err != nil {
+		return err
+	}
+
+	// Load whitelist if needed
+	if checkWl {
+		if err := loadWhitelist(); err != nil {
+			_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: %s}}::yellow", time.Now().Format(time.Stamp), err.Error()))
+		}
+	}
+
+	// Load USB IDs database
+	if err := loadUSBIDs(); err != nil {
+		return err
+	}
+
+	if untrusted {
+		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Filtering: only untrusted devices}}::green", time.Now().Format(time.Stamp)))
+	}
+
+	if err := runSource(params); err != nil {
+		return err
+	}
+
+	_, _ = cfmt.Println(cfmt.Sprintf("[*] Completed at: %v", time.Now().Format(time.Stamp)))
+	return nil
+}
+
+// buildParams merges the config file with the flags and builds the parse parameters
+func buildParams() (data.ParseParams, error) {
+	// --path given on the command line takes precedence over the log_path of remote hosts
+	if logPath != defaultLogPath {
+		remotePathFlag = logPath
+	}
+
 	// Merge config with flags
 	mergeConfigWithFlags()
+	if remotePathFlag != "" {
+		remoteLogPath = remotePathFlag
+	}
 
 	// Build parse parameters
 	params := data.ParseParams{
 		Ctx:                rootCtx,
+		Source:             sourceType,
 		LogPath:            logPath,
+		LogInclude:         logInclude,
+		LogExclude:         logExclude,
+		DryRun:             dryRun,
 		WlPath:             whitelist,
 		OnlyMass:           massStorage,
 		CheckWl:            checkWl,
+		ShowIgnored:        showIgnored,
 		Number:             number,
 		Export:             export,
 		Format:             exportFormat,
 		FileName:           exportFile,
+		Evidence:           evidence,
+		Explain:            explain,
 		ExternalUsbIdsPath: usbidsPath,
 		SortBy:             sortBy,
 		Untrusted:          untrusted,
@@ -135,28 +266,34 @@ func runEvents(cmd *cobra.Command, args []string) error {
 		SSHKeyPath:         remoteSSHKey,
 		SSHTimeout:         remoteTimeout,
 		InsecureSSH:        insecureSSH,
+		RemoteLogPath:      remoteLogPath,
 		Workers:            workers,
 		Streaming:          streaming,
+		DBPath:             dbPath,
+		SaveToDB:           saveToDB,
 	}
 
-	// Load whitelist if needed
-	if checkWl {
-		if err := loadWhitelist(); err != nil {
-			_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: %s}}::yellow", time.Now().Format(time.Stamp), err.Error()))
+	if referenceDate != "" {
+		refDate, err := utils.ParseReferenceDate(referenceDate)
+		if err != nil {
+			return params, fmt.Errorf("invalid --reference-date %q: %w", referenceDate, err)
 		}
+		params.ReferenceDate = refDate
+		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Using reference date %s for year inference}}::green",
+			time.Now().Format(time.Stamp), refDate.Format(time.RFC3339)))
 	}
 
-	// Load USB IDs database
-	if err := loadUSBIDs(); err != nil {
-		return err
-	}
+	return params, nil
+}
 
-	if untrusted {
-		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Filtering: only untrusted devices}}::green", time.Now().Format(time.Stamp)))
+// runSource collects the events of params.Source and reports them
+func runSource(params data.ParseParams) error {
+	if params.DryRun && params.Source != "local" && params.Source != "remote" {
+		return fmt.Errorf("--dry-run lists log files, it needs the local or remote source")
 	}
 
 	// Validate and execute based on source
-	switch sourceType {
+	switch params.Source {
 	case "local":
 		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Collecting local events...}}::green", time.Now().Format(time.Stamp)))
 		err := parsers.LocalEvents(params)
@@ -168,15 +305,50 @@ func runEvents(cmd *cobra.Command, args []string) error {
 			return err
 		}
 
+	case "journal":
+		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Collecting journal events...}}::green", time.Now().Format(time.Stamp)))
+		err := parsers.JournalEvents(params)
+		if err != nil {
+			if errors.Is(err, rootCtx.Err()) {
+				_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Operation cancelled by user}}::yellow", time.Now().Format(time.Stamp)))
+				os.Exit(130)
+			}
+			return err
+		}
+
 	case "remote":
+		if allHosts || len(remoteHosts) > 0 || len(remoteGroups) > 0 || len(remoteTags) > 0 {
+			targets, err := remoteTargets()
+			if err != nil {
+				return err
+			}
+			params.Hosts = targets
+			params.HostWorkers = hostWorkers
+			showRemoteWarnings()
+
+			_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Collecting remote events...}}::green", time.Now().Format(time.Stamp)))
+			if err := parsers.RemoteHostsEvents(params); err != nil {
+				if errors.Is(err, rootCtx.Err()) {
+					_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Operation cancelled by user}}::yellow", time.Now().Format(time.Stamp)))
+					os.Exit(130)
+				}
+				return err
+			}
+			break
+		}
+
 		if err := validateRemoteFlags(); err != nil {
 			return err
 		}
+		jumps, err := jumpHosts(configJumpHosts)
+		if err != nil {
+			return err
+		}
+		params.JumpHosts = jumps
 		showRemoteWarnings()
 
 		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Collecting remote events...}}::green", time.Now().Format(time.Stamp)))
-		err := parsers.RemoteEvents(params)
-		if err != nil {
+		if err := parsers.RemoteEvents(params); err != nil {
 			if errors.Is(err, rootCtx.Err()) {
 				_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Operation cancelled by user}}::yellow", time.Now().Format(time.Stamp)))
 				os.Exit(130)
@@ -185,13 +357,16 @@ func runEvents(cmd *cobra.Command, args []string) error {
 		}
 
 	case "database":
-		return fmt.Errorf("database source not yet implemented")
+		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Collecting events from database %s...}}::green", time.Now().Format(time.Stamp), params.DBPath))
+		err := parsers.DatabaseEvents(params)
+		if err != nil {
+			return err
+		}
 
 	default:
-		return fmt.Errorf("unknown source type: %s (use: local, remote, database)", sourceType)
+		return fmt.Errorf("unknown source type: %s (use: local, remote, journal, database)", params.Source)
 	}
 
-	_, _ = cfmt.Println(cfmt.Sprintf("[*] Completed at: %v", time.Now().Format(time.Stamp)))
 	return nil
 }
 
@@ -207,9 +382,15 @@ func mergeConfigWithFlags() {
 	if usbidsPath == "/var/lib/usbutils/usb.ids" && configLoaded.UsbIds != "" {
 		usbidsPath = configLoaded.UsbIds
 	}
-	if logPath == "/var/log/" && configLoaded.LogPath != "" {
+	if logPath == defaultLogPath && configLoaded.LogPath != "" {
 		logPath = configLoaded.LogPath
 	}
+	if len(logInclude) == 0 {
+		logInclude = configLoaded.LogFiles.Include
+	}
+	if len(logExclude) == 0 {
+		logExclude = configLoaded.LogFiles.Exclude
+	}
 	if exportFormat == "pdf" && configLoaded.Export.Format != "" {
 		exportFormat = configLoaded.Export.Format
 	}
@@ -222,6 +403,12 @@ func mergeConfigWithFlags() {
 	if !checkWl && configLoaded.CheckWl {
 		checkWl = configLoaded.CheckWl
 	}
+	if dbPath == database.DefaultPath && configLoaded.Database.Path != "" {
+		dbPath = configLoaded.Database.Path
+	}
+	if !saveToDB && configLoaded.Database.Save {
+		saveToDB = configLoaded.Database.Save
+	}
 
 	// Handle remote host from config
 	if remoteHost != "" {
@@ -235,7 +422,7 @@ func mergeConfigWithFlags() {
 		if remoteIP == "" {
 			remoteIP = host.IP
 		}
-		if remotePort == "22" {
+		if remotePort == "22" && host.Port != "" {
 			remotePort = host.Port
 		}
 		if remoteLogin == "" {
@@ -244,12 +431,14 @@ func mergeConfigWithFlags() {
 		if remoteSSHKey == "" {
 			remoteSSHKey = host.SSHKey
 		}
-		if remoteTimeout == 30 {
+		if remoteTimeout == 30 && host.Timeout != 0 {
 			remoteTimeout = host.Timeout
 		}
 		if !insecureSSH {
 			insecureSSH = host.InsecureSSH
 		}
+		remoteLogPath = host.LogPath
+		configJumpHosts = host.JumpHosts
 
 		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Using remote host from config: %s (%s)}}::green",
 			time.Now().Format(time.Stamp), host.Name, host.IP))
@@ -275,11 +464,10 @@ func loadWhitelist() error {
 
 	// Try default location if custom whitelist not loaded
 	if !whitelistLoaded {
-		defaultWhitelist := "/etc/udev/rules.d/99_PDAC_LOCAL_flash.rules"
-		if _, err := os.Stat(defaultWhitelist); !os.IsNotExist(err) {
-			if err := utils.LoadWhiteList(defaultWhitelist); err == nil {
+		if _, err := os.Stat(defaultWhitelistPath); !os.IsNotExist(err) {
+			if err := utils.LoadWhiteList(defaultWhitelistPath); err == nil {
 				_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Loaded default whitelist from %s}}::green",
-					time.Now().Format(time.Stamp), defaultWhitelist))
+					time.Now().Format(time.Stamp), defaultWhitelistPath))
 				whitelistLoaded = true
 			}
 		}
@@ -309,17 +497,134 @@ func loadUSBIDs() error {
 	return nil
 }
 
+// validateRemoteFlags checks that a remote host is given. The user and keys may
+// also come from ~/.ssh/config and ssh-agent, so only the host is required.
 func validateRemoteFlags() error {
 	if remoteIP == "" && remoteHost == "" {
 		return fmt.Errorf("remote source requires --remote-ip or --remote-host")
 	}
-	if remoteLogin == "" {
-		return fmt.Errorf("remote source requires --remote-login")
+	return nil
+}
+
+// remoteTargets returns the config file hosts selected by --all-hosts, --hosts,
+// --group and --tag. Connection flags given on the command line apply to every host.
+func remoteTargets() ([]data.RemoteTarget, error) {
+	if remoteHost != "" || remoteIP != "" {
+		return nil, fmt.Errorf("--remote-host and --remote-ip can't be combined with --all-hosts, --hosts, --group or --tag")
 	}
-	if remotePass == "" && remoteSSHKey == "" {
-		return fmt.Errorf("remote source requires --remote-password or --remote-key")
+	if configLoaded == nil {
+		return nil, fmt.Errorf("--all-hosts, --hosts, --group and --tag need remote hosts in the config file")
 	}
-	return nil
+
+	hosts, err := configLoaded.SelectRemoteHosts(config.HostSelector{
+		All:    allHosts,
+		Names:  remoteHosts,
+		Groups: remoteGroups,
+		Tags:   remoteTags,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []data.RemoteTarget
+	var problems []error
+	for _, host := range hosts {
+		target := data.RemoteTarget{
+			Name:        host.Name,
+			IP:          host.IP,
+			Port:        host.Port,
+			Login:       host.User,
+			Password:    host.Password,
+			SSHKeyPath:  host.SSHKey,
+			SSHTimeout:  host.Timeout,
+			InsecureSSH: host.InsecureSSH || insecureSSH,
+			LogPath:     host.LogPath,
+		}
+		if remotePort != "22" || target.Port == "" {
+			target.Port = remotePort
+		}
+		if remoteLogin != "" {
+			target.Login = remoteLogin
+		}
+		if remotePass != "" {
+			target.Password = remotePass
+		}
+		if remoteSSHKey != "" {
+			target.SSHKeyPath = remoteSSHKey
+		}
+		if remoteTimeout != 30 || target.SSHTimeout == 0 {
+			target.SSHTimeout = remoteTimeout
+		}
+		if remotePathFlag != "" {
+			target.LogPath = remotePathFlag
+		}
+
+		// The user and keys may also come from ~/.ssh/config and ssh-agent
+		if target.IP == "" {
+			problems = append(problems, fmt.Errorf("remote host '%s': ip is required", host.Name))
+		}
+		target.JumpHosts, err = jumpHosts(host.JumpHosts)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("remote host '%s': %w", host.Name, err))
+		}
+
+		if target.InsecureSSH && !insecureSSH {
+			_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] ⚠️  WARNING: SSH host key verification is DISABLED for %s!}}::bgRed|white|bold",
+				time.Now().Format(time.Stamp), host.Name))
+		}
+		targets = append(targets, target)
+	}
+
+	if len(problems) > 0 {
+		return nil, errors.Join(problems...)
+	}
+	return targets, nil
+}
+
+// jumpHosts returns the jump hosts given with --jump-host, or else the configured
+// ones. --jump-key and --insecure-ssh apply to every jump host.
+func jumpHosts(configured []config.JumpHost) ([]data.JumpHost, error) {
+	var hops []data.JumpHost
+
+	if len(jumpHostSpecs) > 0 {
+		for _, spec := range jumpHostSpecs {
+			hop := utils.ParseJumpHost(spec)
+			if hop.Host == "" {
+				return nil, fmt.Errorf("invalid jump host '%s', expected [user@]host[:port]", spec)
+			}
+			hops = append(hops, data.JumpHost{
+				Host:        hop.Host,
+				Port:        hop.Port,
+				Login:       hop.User,
+				SSHKeyPath:  jumpKey,
+				InsecureSSH: insecureSSH,
+			})
+		}
+		return hops, nil
+	}
+
+	for i, jump := range configured {
+		if jump.Host == "" {
+			return nil, fmt.Errorf("jump host #%d: host is required", i)
+		}
+		hop := data.JumpHost{
+			Host:        jump.Host,
+			Port:        jump.Port,
+			Login:       jump.User,
+			Password:    jump.Password,
+			SSHKeyPath:  jump.SSHKey,
+			InsecureSSH: jump.InsecureSSH || insecureSSH,
+		}
+		if jumpKey != "" {
+			hop.SSHKeyPath = jumpKey
+		}
+		if hop.InsecureSSH && !insecureSSH {
+			_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] ⚠️  WARNING: SSH host key verification is DISABLED for jump host %s!}}::bgRed|white|bold",
+				time.Now().Format(time.Stamp), jump.Host))
+		}
+		hops = append(hops, hop)
+	}
+	return hops, nil
 }
 
 func showRemoteWarnings() {
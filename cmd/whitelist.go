@@ -0,0 +1,377 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/i582/cfmt/cmd/cfmt"
+	"github.com/pixfid/luft/core/utils"
+	"github.com/pixfid/luft/data"
+	"github.com/spf13/cobra"
+)
+
+// defaultWhitelistPath is the udev rules file read when no whitelist is configured
+const defaultWhitelistPath = "/etc/udev/rules.d/99_PDAC_LOCAL_flash.rules"
+
+var (
+	// Whitelist generation flags
+	generateFormat string
+	generateOutput string
+	generateSince  string
+	generateUntil  string
+
+	// Whitelist editing flags
+	whitelistEntry utils.WhiteListEntry
+
+	// Whitelist check flags
+	checkSerial       string
+	checkManufacturer string
+	checkProduct      string
+	checkHost         string
+	checkDate         string
+)
+
+var whitelistCmd = &cobra.Command{
+	Use:   "whitelist",
+	Short: "Manage USB device whitelists",
+	Long: `Build and maintain the whitelists used by --check-whitelist.
+
+Available commands:
+  - list:     show the rules of a whitelist
+  - add:      add a rule to a whitelist
+  - remove:   remove rules by name, serial number or VID:PID
+  - check:    tell whether a device is allowed by a whitelist
+  - lint:     report invalid, duplicate and expired rules
+  - generate: write a whitelist of the devices seen by any event source
+  - convert:  convert a whitelist to another format, e.g. a USBGuard policy
+
+Whitelists are read as YAML, JSON, udev rules or USBGuard rules.conf files.
+The list, add, remove, check and lint commands work on the file given with
+--whitelist, then the one from the config file, then the default udev rules
+file ` + defaultWhitelistPath + `.
+Edits keep the file format and save the previous version as <file>.bak.`,
+}
+
+var whitelistListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List whitelist rules",
+	Args:  cobra.NoArgs,
+	RunE:  runWhitelistList,
+}
+
+var whitelistAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add a rule to a whitelist",
+	Long: `Add a rule to a whitelist, creating the file when it does not exist.
+
+udev rules files only hold serial number rules, and USBGuard policies can't
+match manufacturer, host or validity period; use a YAML or JSON whitelist for
+those.
+
+Examples:
+  # Allow a flash drive by serial number
+  luft whitelist add --serial 4C530001230921115325 --comment "backup drive"
+
+  # Allow a keyboard model on the office workstations until the end of the year
+  luft whitelist add --whitelist whitelist.yaml --name "office keyboards" \
+    --vid 046d --pid c31c --host "ws-*" --valid-until 2025-12-31`,
+	Args: cobra.NoArgs,
+	RunE: runWhitelistAdd,
+}
+
+var whitelistRemoveCmd = &cobra.Command{
+	Use:   "remove <name|serial|vid:pid>",
+	Short: "Remove whitelist rules",
+	Long: `Remove every rule whose name, serial number or VID:PID is the given key.
+
+Examples:
+  luft whitelist remove 4C530001230921115325
+  luft whitelist remove --whitelist whitelist.yaml 046d:c31c`,
+	Args: cobra.ExactArgs(1),
+	RunE: runWhitelistRemove,
+}
+
+var whitelistCheckCmd = &cobra.Command{
+	Use:   "check <serial|vid:pid>",
+	Short: "Check a device against a whitelist",
+	Long: `Tell which rule decides a device and whether the device is trusted.
+The command fails when the device is not trusted.
+
+Examples:
+  luft whitelist check 4C530001230921115325
+  luft whitelist check 0781:5567 --serial 4C530001230921115325 --host ws-01 --date 2025-03-04
+  luft whitelist check 0781:5567 --manufacturer SanDisk --product "Cruzer Blade"`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE:         runWhitelistCheck,
+}
+
+var whitelistLintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Check a whitelist for problems",
+	Long: `Report rules that can't be parsed, rules duplicating an earlier rule and
+rules whose validity period has ended. The command fails when any are found.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE:         runWhitelistLint,
+}
+
+var whitelistGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Generate a whitelist from observed devices",
+	Long: `Generate whitelist rules for the devices found by an event source.
+
+Devices are deduplicated by VID, PID and serial number, and each rule carries the
+manufacturer and product names from the USB IDs database as its comment.
+
+Formats:
+  - udev: serial number rules in the udev rules format read by --whitelist
+          (devices without a serial number are left out)
+  - yaml: structured whitelist, devices without a serial number are allowed by VID/PID
+  - json: same as yaml, in JSON
+  - usbguard: USBGuard rules.conf allow rules by id and serial number
+
+Examples:
+  # Whitelist every mass storage device seen locally
+  luft whitelist generate --source local --mass-storage
+
+  # Whitelist the devices connected to a workstation during its first week
+  luft whitelist generate --source remote --remote-host ws-01 \
+    --since 2025-01-06 --until 2025-01-12 --format yaml --output ws-01
+
+  # Build a whitelist from the events database
+  luft whitelist generate --source database --format json
+
+  # USBGuard policy of the devices a host has seen
+  luft whitelist generate --source local --format usbguard --output rules`,
+	RunE: runWhitelistGenerate,
+}
+
+var whitelistConvertCmd = &cobra.Command{
+	Use:   "convert",
+	Short: "Convert a whitelist to another format",
+	Long: `Convert the whitelist given with --whitelist (or the one from the config file)
+to another format. Rules the target format can't express, such as host or
+validity limits in a USBGuard policy, are reported and left out.
+
+Examples:
+  # Audit with the USBGuard policy of a host, and convert it for editing
+  luft whitelist convert --whitelist /etc/usbguard/rules.conf --format yaml
+
+  # Enforce a luft whitelist with USBGuard
+  luft whitelist convert --whitelist whitelist.yaml --format usbguard --output rules`,
+	RunE: runWhitelistConvert,
+}
+
+func init() {
+	rootCmd.AddCommand(whitelistCmd)
+	whitelistCmd.AddCommand(whitelistListCmd)
+	whitelistCmd.AddCommand(whitelistAddCmd)
+	whitelistCmd.AddCommand(whitelistRemoveCmd)
+	whitelistCmd.AddCommand(whitelistCheckCmd)
+	whitelistCmd.AddCommand(whitelistLintCmd)
+	whitelistCmd.AddCommand(whitelistGenerateCmd)
+	whitelistCmd.AddCommand(whitelistConvertCmd)
+
+	for _, cmd := range []*cobra.Command{whitelistListCmd, whitelistAddCmd, whitelistRemoveCmd, whitelistCheckCmd, whitelistLintCmd} {
+		cmd.Flags().StringVarP(&whitelist, "whitelist", "W", "", "whitelist file path (YAML, JSON, udev or USBGuard rules)")
+	}
+
+	// Rule flags
+	whitelistAddCmd.Flags().StringVar(&whitelistEntry.Name, "name", "", "rule name")
+	whitelistAddCmd.Flags().StringVar(&whitelistEntry.Vid, "vid", "", "vendor ID (4 hex digits or a glob)")
+	whitelistAddCmd.Flags().StringVar(&whitelistEntry.Pid, "pid", "", "product ID (4 hex digits or a glob)")
+	whitelistAddCmd.Flags().StringVar(&whitelistEntry.Serial, "serial", "", "serial number or glob")
+	whitelistAddCmd.Flags().StringVar(&whitelistEntry.Manufacturer, "manufacturer", "", "manufacturer name or glob")
+	whitelistAddCmd.Flags().StringVar(&whitelistEntry.Product, "product", "", "product name or glob")
+	whitelistAddCmd.Flags().StringVar(&whitelistEntry.Host, "host", "", "host name or glob")
+	whitelistAddCmd.Flags().StringVar(&whitelistEntry.Port, "port", "", "bus-port path or glob, e.g. 1-2.3")
+	whitelistAddCmd.Flags().StringVar(&whitelistEntry.ValidFrom, "valid-from", "", "start of the validity period (YYYY-MM-DD or RFC 3339)")
+	whitelistAddCmd.Flags().StringVar(&whitelistEntry.ValidUntil, "valid-until", "", "end of the validity period (YYYY-MM-DD or RFC 3339)")
+	whitelistAddCmd.Flags().BoolVar(&whitelistEntry.Ignore, "ignore", false, "hide matching devices from reports")
+	whitelistAddCmd.Flags().BoolVar(&whitelistEntry.Block, "block", false, "mark matching devices untrusted")
+	whitelistAddCmd.Flags().StringVar(&whitelistEntry.Comment, "comment", "", "rule comment")
+
+	// Device flags
+	whitelistCheckCmd.Flags().StringVar(&checkSerial, "serial", "", "device serial number, with a vid:pid argument")
+	whitelistCheckCmd.Flags().StringVar(&checkManufacturer, "manufacturer", "", "manufacturer string the device reported")
+	whitelistCheckCmd.Flags().StringVar(&checkProduct, "product", "", "product string the device reported")
+	whitelistCheckCmd.Flags().StringVar(&checkHost, "host", "", "host the device was connected to")
+	whitelistCheckCmd.Flags().StringVar(&checkDate, "date", "", "connection time (YYYY-MM-DD or RFC 3339, default now)")
+
+	addSourceFlags(whitelistGenerateCmd)
+
+	// Filter flags
+	whitelistGenerateCmd.Flags().BoolVarP(&massStorage, "mass-storage", "m", false, "only mass storage devices")
+	whitelistGenerateCmd.Flags().StringVar(&generateSince, "since", "", "only devices connected on or after this date (YYYY-MM-DD or RFC 3339)")
+	whitelistGenerateCmd.Flags().StringVar(&generateUntil, "until", "", "only devices connected on or before this date (YYYY-MM-DD or RFC 3339)")
+
+	// Output flags
+	whitelistGenerateCmd.Flags().StringVarP(&generateFormat, "format", "F", "udev", "whitelist format (udev, yaml, json, usbguard)")
+	whitelistGenerateCmd.Flags().StringVarP(&generateOutput, "output", "o", "whitelist", "whitelist filename (without extension)")
+
+	whitelistConvertCmd.Flags().StringVarP(&whitelist, "whitelist", "W", "", "whitelist file path (YAML, JSON, udev or USBGuard rules)")
+	whitelistConvertCmd.Flags().StringVarP(&generateFormat, "format", "F", "usbguard", "target format (udev, yaml, json, usbguard)")
+	whitelistConvertCmd.Flags().StringVarP(&generateOutput, "output", "o", "whitelist", "target filename (without extension)")
+}
+
+func runWhitelistGenerate(cmd *cobra.Command, args []string) error {
+	params, err := buildParams()
+	if err != nil {
+		return err
+	}
+	params.Generate = generateFormat
+	params.FileName = generateOutput
+	params.OnlyMass = massStorage
+
+	if generateSince != "" {
+		if params.Since, err = utils.ParseDateBound(generateSince, false); err != nil {
+			return fmt.Errorf("invalid --since %q: %w", generateSince, err)
+		}
+	}
+	if generateUntil != "" {
+		if params.Until, err = utils.ParseDateBound(generateUntil, true); err != nil {
+			return fmt.Errorf("invalid --until %q: %w", generateUntil, err)
+		}
+	}
+
+	// Every matching device goes to the whitelist, ignore listing filters set in the config file
+	params.CheckWl = false
+	params.Untrusted = false
+	params.Number = 0
+
+	// Comments are taken from the USB IDs database
+	if err := loadUSBIDs(); err != nil {
+		return err
+	}
+
+	if err := runSource(params); err != nil {
+		return err
+	}
+
+	_, _ = cfmt.Println(cfmt.Sprintf("[*] Completed at: %v", time.Now().Format(time.Stamp)))
+	return nil
+}
+
+func runWhitelistConvert(cmd *cobra.Command, args []string) error {
+	mergeConfigWithFlags()
+
+	if err := loadWhitelist(); err != nil {
+		return err
+	}
+
+	return utils.ConvertWhiteList(generateFormat, generateOutput)
+}
+
+// resolveWhitelistPath returns the whitelist file to work on: the --whitelist
+// flag, then the config file, then the default udev rules file
+func resolveWhitelistPath() string {
+	mergeConfigWithFlags()
+
+	if whitelist != "" {
+		return whitelist
+	}
+	return defaultWhitelistPath
+}
+
+func runWhitelistList(cmd *cobra.Command, args []string) error {
+	return utils.ListWhiteList(resolveWhitelistPath())
+}
+
+func runWhitelistAdd(cmd *cobra.Command, args []string) error {
+	wlPath := resolveWhitelistPath()
+
+	if err := utils.AddWhiteListEntry(wlPath, whitelistEntry); err != nil {
+		return fmt.Errorf("failed to add rule to %s: %w", wlPath, err)
+	}
+
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Rule added to %s}}::green", time.Now().Format(time.Stamp), wlPath))
+	return nil
+}
+
+func runWhitelistRemove(cmd *cobra.Command, args []string) error {
+	wlPath := resolveWhitelistPath()
+
+	removed, err := utils.RemoveWhiteListEntries(wlPath, args[0])
+	if err != nil {
+		return fmt.Errorf("failed to remove rules from %s: %w", wlPath, err)
+	}
+	if removed == 0 {
+		return fmt.Errorf("no rule of %s matches %s", wlPath, args[0])
+	}
+
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Removed %d rules from %s}}::green", time.Now().Format(time.Stamp), removed, wlPath))
+	return nil
+}
+
+func runWhitelistCheck(cmd *cobra.Command, args []string) error {
+	event := data.Event{
+		SerialNumber:     checkSerial,
+		ManufacturerName: checkManufacturer,
+		ProductName:      checkProduct,
+		Host:             checkHost,
+		// Rules with a validity period need a connection time
+		ConnectedTime: time.Now(),
+	}
+
+	if vid, pid, ok := strings.Cut(args[0], ":"); ok {
+		event.Vid, event.Pid = strings.ToLower(vid), strings.ToLower(pid)
+	} else {
+		if checkSerial != "" {
+			return fmt.Errorf("give the serial number either as argument or with --serial")
+		}
+		event.SerialNumber = args[0]
+	}
+
+	if checkDate != "" {
+		date, err := utils.ParseDateBound(checkDate, false)
+		if err != nil {
+			return fmt.Errorf("invalid --date %q: %w", checkDate, err)
+		}
+		event.ConnectedTime = date
+	}
+
+	wlPath := resolveWhitelistPath()
+	if err := utils.LoadWhiteList(wlPath); err != nil {
+		return fmt.Errorf("failed to load whitelist %s: %w", wlPath, err)
+	}
+
+	rule := utils.MatchWhiteList(event)
+	switch {
+	case rule == nil:
+		return fmt.Errorf("device %s is not trusted, no whitelist rule matches it", args[0])
+	case rule.Deny:
+		return fmt.Errorf("device %s is not trusted, blocked by rule %s", args[0], rule.String())
+	case rule.IsIgnore:
+		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Device %s is trusted and ignored by rule %s}}::green",
+			time.Now().Format(time.Stamp), args[0], rule.String()))
+	default:
+		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Device %s is trusted by rule %s}}::green",
+			time.Now().Format(time.Stamp), args[0], rule.String()))
+	}
+
+	if rule.Commentary != "" {
+		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Comment: %s}}::green", time.Now().Format(time.Stamp), rule.Commentary))
+	}
+	return nil
+}
+
+func runWhitelistLint(cmd *cobra.Command, args []string) error {
+	wlPath := resolveWhitelistPath()
+
+	problems, err := utils.LintWhiteList(wlPath)
+	if err != nil {
+		return err
+	}
+
+	for _, problem := range problems {
+		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] %s}}::yellow", time.Now().Format(time.Stamp), problem))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s has %d problems", wlPath, len(problems))
+	}
+
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] %s has no problems}}::green", time.Now().Format(time.Stamp), wlPath))
+	return nil
+}
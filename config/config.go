@@ -10,14 +10,17 @@ import (
 
 // Config represents the application configuration
 type Config struct {
-	Whitelist   string       `mapstructure:"whitelist" yaml:"whitelist"`
-	UsbIds      string       `mapstructure:"usbids" yaml:"usbids"`
-	LogPath     string       `mapstructure:"log_path" yaml:"log_path"`
-	MassStorage bool         `mapstructure:"mass_storage" yaml:"mass_storage"`
-	Untrusted   bool         `mapstructure:"untrusted" yaml:"untrusted"`
-	CheckWl     bool         `mapstructure:"check_whitelist" yaml:"check_whitelist"`
-	Export      ExportConfig `mapstructure:"export" yaml:"export"`
-	RemoteHosts []RemoteHost `mapstructure:"remote_hosts" yaml:"remote_hosts"`
+	Whitelist   string         `mapstructure:"whitelist" yaml:"whitelist"`
+	UsbIds      string         `mapstructure:"usbids" yaml:"usbids"`
+	LogPath     string         `mapstructure:"log_path" yaml:"log_path"`
+	MassStorage bool           `mapstructure:"mass_storage" yaml:"mass_storage"`
+	Untrusted   bool           `mapstructure:"untrusted" yaml:"untrusted"`
+	CheckWl     bool           `mapstructure:"check_whitelist" yaml:"check_whitelist"`
+	Export      ExportConfig   `mapstructure:"export" yaml:"export"`
+	Database    DatabaseConfig `mapstructure:"database" yaml:"database"`
+	LogFiles    LogFilesConfig `mapstructure:"log_files" yaml:"log_files"`
+	HostGroups  []HostGroup    `mapstructure:"host_groups" yaml:"host_groups"`
+	RemoteHosts []RemoteHost   `mapstructure:"remote_hosts" yaml:"remote_hosts"`
 }
 
 // ExportConfig represents export configuration
@@ -26,16 +29,64 @@ type ExportConfig struct {
 	Path   string `mapstructure:"path" yaml:"path"`
 }
 
+// DatabaseConfig represents the events database configuration
+type DatabaseConfig struct {
+	Path string `mapstructure:"path" yaml:"path"`
+	Save bool   `mapstructure:"save" yaml:"save"`
+}
+
+// LogFilesConfig selects the log files parsed, with globs or re: prefixed regexps
+type LogFilesConfig struct {
+	Include []string `mapstructure:"include" yaml:"include,omitempty"`
+	Exclude []string `mapstructure:"exclude" yaml:"exclude,omitempty"`
+}
+
 // RemoteHost represents a remote host configuration
 type RemoteHost struct {
-	Name        string `mapstructure:"name" yaml:"name"`
-	IP          string `mapstructure:"ip" yaml:"ip"`
-	Port        string `mapstructure:"port" yaml:"port"`
-	User        string `mapstructure:"user" yaml:"user"`
-	SSHKey      string `mapstructure:"ssh_key" yaml:"ssh_key"`
+	Name        string     `mapstructure:"name" yaml:"name"`
+	IP          string     `mapstructure:"ip" yaml:"ip"`
+	Port        string     `mapstructure:"port" yaml:"port"`
+	User        string     `mapstructure:"user" yaml:"user"`
+	SSHKey      string     `mapstructure:"ssh_key" yaml:"ssh_key"`
+	Password    string     `mapstructure:"password,omitempty" yaml:"password,omitempty"`
+	Timeout     int        `mapstructure:"timeout" yaml:"timeout"`
+	InsecureSSH bool       `mapstructure:"insecure_ssh" yaml:"insecure_ssh"`
+	LogPath     string     `mapstructure:"log_path" yaml:"log_path,omitempty"`
+	JumpHosts   []JumpHost `mapstructure:"jump_hosts" yaml:"jump_hosts,omitempty"`
+	Tags        []string   `mapstructure:"tags" yaml:"tags,omitempty"`
+	Groups      []string   `mapstructure:"groups" yaml:"groups,omitempty"`
+}
+
+// JumpHost is a bastion a remote host is reached through. Jump hosts are
+// connected to in the order they are listed, each with its own credentials.
+type JumpHost struct {
+	Host        string `mapstructure:"host" yaml:"host"`
+	Port        string `mapstructure:"port" yaml:"port,omitempty"`
+	User        string `mapstructure:"user" yaml:"user,omitempty"`
+	SSHKey      string `mapstructure:"ssh_key" yaml:"ssh_key,omitempty"`
 	Password    string `mapstructure:"password,omitempty" yaml:"password,omitempty"`
-	Timeout     int    `mapstructure:"timeout" yaml:"timeout"`
-	InsecureSSH bool   `mapstructure:"insecure_ssh" yaml:"insecure_ssh"`
+	InsecureSSH bool   `mapstructure:"insecure_ssh" yaml:"insecure_ssh,omitempty"`
+}
+
+// HostGroup is a named group of remote hosts. Its settings are the defaults of
+// its members, and its tags are added to theirs.
+type HostGroup struct {
+	Name      string     `mapstructure:"name" yaml:"name"`
+	Port      string     `mapstructure:"port" yaml:"port,omitempty"`
+	User      string     `mapstructure:"user" yaml:"user,omitempty"`
+	SSHKey    string     `mapstructure:"ssh_key" yaml:"ssh_key,omitempty"`
+	Timeout   int        `mapstructure:"timeout" yaml:"timeout,omitempty"`
+	LogPath   string     `mapstructure:"log_path" yaml:"log_path,omitempty"`
+	JumpHosts []JumpHost `mapstructure:"jump_hosts" yaml:"jump_hosts,omitempty"`
+	Tags      []string   `mapstructure:"tags" yaml:"tags,omitempty"`
+}
+
+// HostSelector picks remote hosts from the configuration
+type HostSelector struct {
+	All    bool
+	Names  []string
+	Groups []string
+	Tags   []string
 }
 
 // DefaultConfig returns configuration with default values
@@ -50,6 +101,9 @@ func DefaultConfig() *Config {
 			Format: "pdf",
 			Path:   ".",
 		},
+		Database: DatabaseConfig{
+			Path: "~/.local/share/luft/luft.db",
+		},
 		RemoteHosts: []RemoteHost{},
 	}
 }
@@ -97,6 +151,13 @@ func Load(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("error unmarshaling config: %w", err)
 	}
 
+	if err := cfg.applyHostGroups(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
 	// Expand paths
 	if cfg.Whitelist != "" {
 		cfg.Whitelist = expandPath(cfg.Whitelist)
@@ -107,10 +168,21 @@ func Load(configPath string) (*Config, error) {
 	if cfg.Export.Path != "" {
 		cfg.Export.Path = expandPath(cfg.Export.Path)
 	}
+	if cfg.Database.Path != "" {
+		cfg.Database.Path = expandPath(cfg.Database.Path)
+	}
 	for i := range cfg.RemoteHosts {
 		if cfg.RemoteHosts[i].SSHKey != "" {
 			cfg.RemoteHosts[i].SSHKey = expandPath(cfg.RemoteHosts[i].SSHKey)
 		}
+		// Hosts of a group share its jump host list, copy it before expanding
+		jumpHosts := append([]JumpHost(nil), cfg.RemoteHosts[i].JumpHosts...)
+		for j := range jumpHosts {
+			if jumpHosts[j].SSHKey != "" {
+				jumpHosts[j].SSHKey = expandPath(jumpHosts[j].SSHKey)
+			}
+		}
+		cfg.RemoteHosts[i].JumpHosts = jumpHosts
 	}
 
 	return cfg, nil
@@ -125,6 +197,8 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("check_whitelist", false)
 	v.SetDefault("export.format", "pdf")
 	v.SetDefault("export.path", ".")
+	v.SetDefault("database.path", "~/.local/share/luft/luft.db")
+	v.SetDefault("database.save", false)
 }
 
 // expandPath expands ~ to home directory
@@ -148,7 +222,129 @@ func (c *Config) GetRemoteHost(name string) (*RemoteHost, error) {
 	return nil, fmt.Errorf("remote host '%s' not found in configuration", name)
 }
 
-// Validate validates the configuration
+// applyHostGroups fills the settings a remote host leaves unset from its groups,
+// in the order they are listed, and adds the group tags to the host
+func (c *Config) applyHostGroups() error {
+	groups := make(map[string]HostGroup)
+	for i, group := range c.HostGroups {
+		if group.Name == "" {
+			return fmt.Errorf("host group #%d: name is required", i)
+		}
+		if _, ok := groups[group.Name]; ok {
+			return fmt.Errorf("host group '%s' is defined twice", group.Name)
+		}
+		groups[group.Name] = group
+	}
+
+	for i := range c.RemoteHosts {
+		host := &c.RemoteHosts[i]
+		for _, name := range host.Groups {
+			group, ok := groups[name]
+			if !ok {
+				return fmt.Errorf("remote host '%s': unknown host group '%s'", host.Name, name)
+			}
+
+			if host.Port == "" {
+				host.Port = group.Port
+			}
+			if host.User == "" {
+				host.User = group.User
+			}
+			if host.SSHKey == "" {
+				host.SSHKey = group.SSHKey
+			}
+			if host.Timeout == 0 {
+				host.Timeout = group.Timeout
+			}
+			if host.LogPath == "" {
+				host.LogPath = group.LogPath
+			}
+			if len(host.JumpHosts) == 0 {
+				host.JumpHosts = group.JumpHosts
+			}
+			for _, tag := range group.Tags {
+				if !host.HasTag(tag) {
+					host.Tags = append(host.Tags, tag)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// SelectRemoteHosts returns the remote hosts picked by selector, in configuration
+// order: every host with All set, otherwise the hosts named, in one of the
+// groups or carrying one of the tags
+func (c *Config) SelectRemoteHosts(selector HostSelector) ([]RemoteHost, error) {
+	selected := make(map[string]bool)
+
+	for _, name := range selector.Names {
+		if _, err := c.GetRemoteHost(name); err != nil {
+			return nil, err
+		}
+		selected[name] = true
+	}
+
+	for _, group := range selector.Groups {
+		found := false
+		for _, host := range c.RemoteHosts {
+			if host.InGroup(group) {
+				selected[host.Name] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no remote host in group '%s' in configuration", group)
+		}
+	}
+
+	for _, tag := range selector.Tags {
+		found := false
+		for _, host := range c.RemoteHosts {
+			if host.HasTag(tag) {
+				selected[host.Name] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no remote host tagged '%s' in configuration", tag)
+		}
+	}
+
+	var hosts []RemoteHost
+	for _, host := range c.RemoteHosts {
+		if selector.All || selected[host.Name] {
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no remote hosts in configuration")
+	}
+	return hosts, nil
+}
+
+// InGroup reports whether the host belongs to group
+func (h RemoteHost) InGroup(group string) bool {
+	for _, g := range h.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// HasTag reports whether the host carries tag
+func (h RemoteHost) HasTag(tag string) bool {
+	for _, t := range h.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate validates the configuration, once host groups are applied
 func (c *Config) Validate() error {
 	// Validate export format
 	validFormats := map[string]bool{"json": true, "xml": true, "pdf": true}
@@ -164,17 +360,11 @@ func (c *Config) Validate() error {
 		if host.IP == "" {
 			return fmt.Errorf("remote host '%s': IP is required", host.Name)
 		}
-		if host.User == "" {
-			return fmt.Errorf("remote host '%s': user is required", host.Name)
-		}
-		if host.SSHKey == "" && host.Password == "" {
-			return fmt.Errorf("remote host '%s': either ssh_key or password is required", host.Name)
-		}
-		if host.Port == "" {
-			host.Port = "22"
-		}
-		if host.Timeout == 0 {
-			host.Timeout = 30
+		// The user and keys may also come from ~/.ssh/config and ssh-agent
+		for j, jump := range host.JumpHosts {
+			if jump.Host == "" {
+				return fmt.Errorf("remote host '%s': jump host #%d: host is required", host.Name, j)
+			}
 		}
 	}
 
@@ -0,0 +1,247 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pixfid/luft/data"
+	"github.com/pixfid/luft/usbids"
+)
+
+// BadUSB rule names
+const (
+	RuleCompositeStorageHID = "composite-storage-hid"
+	RuleProgrammableHID     = "programmable-board-hid"
+	RuleUnknownHIDVendor    = "hid-vendor-not-keyboard"
+	RuleReEnumeration       = "re-enumeration"
+)
+
+// DefaultWindow is the default time between two sessions on a port for the
+// second one to count as a re-enumeration of the same device
+const DefaultWindow = 10 * time.Second
+
+// programmableVendors are vendors of microcontroller boards commonly flashed
+// as keystroke injectors (Rubber Ducky clones, Digispark, Teensy, Pico)
+var programmableVendors = map[string]string{
+	"2341": "Arduino",
+	"2a03": "Arduino",
+	"16c0": "Teensy / V-USB",
+	"16d0": "Digispark",
+	"239a": "Adafruit",
+	"2e8a": "Raspberry Pi Pico",
+	"1b4f": "SparkFun",
+	"1209": "pid.codes",
+}
+
+// BadUSB flags devices that look like HID injection tools:
+//   - composite devices exposing both mass storage and HID interfaces,
+//   - HID keyboards and keypads from programmable board vendors or from vendors
+//     with no keyboard in the USB IDs database,
+//   - devices that re-enumerate on the same port within window with different
+//     descriptors or interface classes.
+func BadUSB(events []data.Event, window time.Duration) []data.Finding {
+	var findings []data.Finding
+
+	for _, event := range events {
+		if reason, ok := compositeStorageHID(event); ok {
+			findings = append(findings, data.Finding{Event: event, Rule: RuleCompositeStorageHID, Reason: reason})
+		}
+		class, ok := keyboardInterface(event)
+		if !ok {
+			continue
+		}
+		vid := strings.ToLower(event.Vid)
+		switch {
+		case programmableVendors[vid] != "":
+			findings = append(findings, data.Finding{
+				Event:  event,
+				Rule:   RuleProgrammableHID,
+				Reason: fmt.Sprintf("%s device from programmable board vendor %s (%s)", class, vid, programmableVendors[vid]),
+			})
+		case !usbids.IsKnownVendor(vid):
+			findings = append(findings, data.Finding{
+				Event:  event,
+				Rule:   RuleUnknownHIDVendor,
+				Reason: fmt.Sprintf("%s device with vendor %s not listed in usb.ids", class, vid),
+			})
+		case !usbids.IsKeyboardVendor(vid):
+			findings = append(findings, data.Finding{
+				Event:  event,
+				Rule:   RuleUnknownHIDVendor,
+				Reason: fmt.Sprintf("%s device from %s (%s), which lists no keyboards in usb.ids", class, event.ManufacturerName, vid),
+			})
+		}
+	}
+
+	findings = append(findings, reEnumerations(events, window)...)
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Event.ConnectedTime.Before(findings[j].Event.ConnectedTime)
+	})
+
+	return findings
+}
+
+// compositeStorageHID reports devices with both storage and HID interfaces
+func compositeStorageHID(event data.Event) (string, bool) {
+	storage := event.IsMassStorage
+	var hid []string
+	for _, iface := range event.Interfaces {
+		switch {
+		case iface.Class == "Mass Storage":
+			storage = true
+		case strings.HasPrefix(iface.Class, "HID"):
+			hid = append(hid, iface.Class)
+		}
+	}
+
+	if !storage || len(hid) == 0 {
+		return "", false
+	}
+	return fmt.Sprintf("mass storage device also exposes %s interface", strings.Join(unique(hid), ", ")), true
+}
+
+// keyboardInterface returns the class of the first HID interface of a device able
+// to type, a keyboard or keypad. A plain HID interface doesn't count, it is all
+// the log tells of mice, headsets and vendor specific HID interfaces.
+func keyboardInterface(event data.Event) (string, bool) {
+	for _, iface := range event.Interfaces {
+		switch iface.Class {
+		case "HID Keyboard", "HID Keypad":
+			return iface.Class, true
+		}
+	}
+	return "", false
+}
+
+// sessionStart returns the connection time of a session, falling back to the
+// time since boot for dmesg output
+func sessionStart(event data.Event) time.Duration {
+	if event.ConnectedTime.IsZero() {
+		return event.Monotonic
+	}
+	return time.Duration(event.ConnectedTime.UnixNano())
+}
+
+// sessionEnd returns the disconnect time of a closed session, or its start
+func sessionEnd(event data.Event) time.Duration {
+	switch {
+	case event.SessionOpen:
+		return sessionStart(event)
+	case !event.DisconnectionTime.IsZero():
+		return time.Duration(event.DisconnectionTime.UnixNano())
+	}
+	return sessionStart(event) + event.Duration
+}
+
+// reEnumerations flags sessions that follow another session on the same port within
+// window with a different identity
+func reEnumerations(events []data.Event, window time.Duration) []data.Finding {
+	type port struct {
+		host string
+		path string
+	}
+
+	byPort := make(map[port][]data.Event)
+	var ports []port
+	for _, event := range events {
+		key := port{host: event.Host, path: event.ConnectionPort}
+		if _, ok := byPort[key]; !ok {
+			ports = append(ports, key)
+		}
+		byPort[key] = append(byPort[key], event)
+	}
+
+	var findings []data.Finding
+	for _, key := range ports {
+		sessions := byPort[key]
+		sort.SliceStable(sessions, func(i, j int) bool {
+			return sessionStart(sessions[i]) < sessionStart(sessions[j])
+		})
+
+		for i := 1; i < len(sessions); i++ {
+			prev, next := sessions[i-1], sessions[i]
+			gap := sessionStart(next) - sessionEnd(prev)
+			if gap < 0 || gap > window {
+				continue
+			}
+
+			changes := descriptorChanges(prev, next)
+			if len(changes) == 0 {
+				continue
+			}
+			findings = append(findings, data.Finding{
+				Event: next,
+				Rule:  RuleReEnumeration,
+				Reason: fmt.Sprintf("re-enumerated on port %s %s with %s",
+					key.path, formatGap(gap), strings.Join(changes, "; ")),
+			})
+		}
+	}
+
+	return findings
+}
+
+// descriptorChanges lists the identity differences of two sessions
+func descriptorChanges(prev, next data.Event) []string {
+	var changes []string
+
+	if !strings.EqualFold(prev.Vid, next.Vid) || !strings.EqualFold(prev.Pid, next.Pid) {
+		changes = append(changes, fmt.Sprintf("VID:PID %s:%s -> %s:%s", prev.Vid, prev.Pid, next.Vid, next.Pid))
+	}
+	if prev.ProductName != next.ProductName {
+		changes = append(changes, fmt.Sprintf("product %q -> %q", prev.ProductName, next.ProductName))
+	}
+	if prev.ManufacturerName != next.ManufacturerName {
+		changes = append(changes, fmt.Sprintf("manufacturer %q -> %q", prev.ManufacturerName, next.ManufacturerName))
+	}
+	if prev.SerialNumber != next.SerialNumber {
+		changes = append(changes, fmt.Sprintf("serial %q -> %q", prev.SerialNumber, next.SerialNumber))
+	}
+	if prevClasses, nextClasses := interfaceClasses(prev), interfaceClasses(next); prevClasses != nextClasses {
+		changes = append(changes, fmt.Sprintf("interfaces %s -> %s", prevClasses, nextClasses))
+	}
+
+	return changes
+}
+
+// interfaceClasses renders the sorted interface classes of a device
+func interfaceClasses(event data.Event) string {
+	var classes []string
+	for _, iface := range event.Interfaces {
+		if iface.Class != "" {
+			classes = append(classes, iface.Class)
+		}
+	}
+	if event.IsMassStorage {
+		classes = append(classes, "Mass Storage")
+	}
+
+	classes = unique(classes)
+	if len(classes) == 0 {
+		return "None"
+	}
+	sort.Strings(classes)
+	return strings.Join(classes, ", ")
+}
+
+func formatGap(gap time.Duration) string {
+	if gap < time.Second {
+		return "within 1s"
+	}
+	return "after " + gap.Round(time.Second).String()
+}
+
+func unique(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var out []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			out = append(out, value)
+		}
+	}
+	return out
+}
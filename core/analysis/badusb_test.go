@@ -0,0 +1,189 @@
+package analysis
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pixfid/luft/data"
+	"github.com/pixfid/luft/usbids"
+)
+
+// loadTestIDs loads a usb.ids with a keyboard vendor and a vendor without
+// keyboards. ParseUsbIDs keeps a vendor once the next one starts, hence the last.
+func loadTestIDs(t *testing.T) {
+	t.Helper()
+	ids := filepath.Join(t.TempDir(), "usb.ids")
+	content := "046d  Logitech, Inc.\n\tc31c  Keyboard K120\n\tc077  M105 Optical Mouse\n" +
+		"0781  SanDisk Corp.\n\t5567  Cruzer Blade\n" +
+		"ffee  Unused\n"
+	if err := os.WriteFile(ids, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := usbids.LoadFromFile(ids); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func hid(class string) data.Interface {
+	return data.Interface{Number: "0", Driver: "hid-generic", Class: class}
+}
+
+func TestBadUSB(t *testing.T) {
+	loadTestIDs(t)
+
+	tests := []struct {
+		name   string
+		event  data.Event
+		rule   string // empty for no finding
+		reason string
+	}{
+		{
+			name:  "keyboard from a keyboard vendor",
+			event: data.Event{Vid: "046d", Pid: "c31c", Interfaces: []data.Interface{hid("HID Keyboard")}},
+		},
+		{
+			name:   "keyboard from a vendor without keyboards",
+			event:  data.Event{Vid: "0781", Pid: "5567", ManufacturerName: "SanDisk", Interfaces: []data.Interface{hid("HID Keyboard")}},
+			rule:   RuleUnknownHIDVendor,
+			reason: "HID Keyboard device from SanDisk (0781), which lists no keyboards in usb.ids",
+		},
+		{
+			name:   "keypad from an unknown vendor",
+			event:  data.Event{Vid: "1234", Pid: "0001", Interfaces: []data.Interface{hid("HID Keypad")}},
+			rule:   RuleUnknownHIDVendor,
+			reason: "HID Keypad device with vendor 1234 not listed in usb.ids",
+		},
+		{
+			name:   "keyboard from a programmable board vendor",
+			event:  data.Event{Vid: "16D0", Pid: "0753", Interfaces: []data.Interface{hid("HID Mouse"), hid("HID Keyboard")}},
+			rule:   RuleProgrammableHID,
+			reason: "HID Keyboard device from programmable board vendor 16d0 (Digispark)",
+		},
+		// A plain HID interface says nothing of the device being able to type
+		{
+			name:  "plain HID from a vendor without keyboards",
+			event: data.Event{Vid: "0781", Pid: "5567", Interfaces: []data.Interface{hid("HID")}},
+		},
+		{
+			name:  "plain HID from a programmable board vendor",
+			event: data.Event{Vid: "2e8a", Pid: "000a", Interfaces: []data.Interface{hid("HID")}},
+		},
+		{
+			name:  "mouse from an unknown vendor",
+			event: data.Event{Vid: "1234", Pid: "0002", Interfaces: []data.Interface{hid("HID Mouse")}},
+		},
+		{
+			name:   "storage with a HID interface",
+			event:  data.Event{Vid: "046d", Pid: "c31c", IsMassStorage: true, Interfaces: []data.Interface{hid("HID")}},
+			rule:   RuleCompositeStorageHID,
+			reason: "mass storage device also exposes HID interface",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings := BadUSB([]data.Event{tt.event}, DefaultWindow)
+
+			if tt.rule == "" {
+				if len(findings) != 0 {
+					t.Errorf("findings = %+v, want none", findings)
+				}
+				return
+			}
+			if len(findings) != 1 || findings[0].Rule != tt.rule || findings[0].Reason != tt.reason {
+				t.Errorf("findings = %+v, want %s: %s", findings, tt.rule, tt.reason)
+			}
+		})
+	}
+}
+
+func TestReEnumerations(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	stick := data.Event{
+		Host: "ws-01", ConnectionPort: "1-2", Vid: "0781", Pid: "5567",
+		ProductName: "Cruzer Blade", ManufacturerName: "SanDisk", SerialNumber: "AAA", IsMassStorage: true,
+	}
+	// session returns the device connected at from and disconnected at to after start
+	session := func(device data.Event, from, to time.Duration) data.Event {
+		device.ConnectedTime = start.Add(from)
+		device.DisconnectionTime = start.Add(to)
+		device.Duration = to - from
+		return device
+	}
+	ducky := stick
+	ducky.Vid, ducky.Pid, ducky.ProductName = "03eb", "2401", "Keyboard"
+	ducky.IsMassStorage = false
+	ducky.Interfaces = []data.Interface{hid("HID Keyboard")}
+
+	otherPort, otherHost := ducky, ducky
+	otherPort.ConnectionPort = "1-3"
+	otherHost.Host = "ws-02"
+
+	tests := []struct {
+		name   string
+		events []data.Event
+		reason string // of the finding, empty for none
+	}{
+		{
+			name:   "new identity within the window",
+			events: []data.Event{session(ducky, 2*time.Minute+2*time.Second, 3*time.Minute), session(stick, 0, 2*time.Minute)},
+			reason: `re-enumerated on port 1-2 after 2s with VID:PID 0781:5567 -> 03eb:2401; product "Cruzer Blade" -> "Keyboard"; interfaces Mass Storage -> HID Keyboard`,
+		},
+		{
+			name:   "within a second",
+			events: []data.Event{session(stick, 0, time.Minute), session(ducky, time.Minute+300*time.Millisecond, 2*time.Minute)},
+			reason: "re-enumerated on port 1-2 within 1s with",
+		},
+		{
+			name:   "previous session left open",
+			events: []data.Event{{Host: "ws-01", ConnectionPort: "1-2", Vid: "0781", Pid: "5567", ConnectedTime: start, SessionOpen: true}, session(ducky, 5*time.Second, time.Minute)},
+			reason: "re-enumerated on port 1-2 after 5s with VID:PID",
+		},
+		{
+			name: "dmesg sessions",
+			events: []data.Event{
+				{ConnectionPort: "1-2", Vid: "0781", Pid: "5567", Monotonic: 100 * time.Second, Duration: 10 * time.Second},
+				{ConnectionPort: "1-2", Vid: "03eb", Pid: "2401", Monotonic: 113 * time.Second},
+			},
+			reason: "re-enumerated on port 1-2 after 3s with VID:PID 0781:5567 -> 03eb:2401",
+		},
+		{
+			name:   "same device plugged again",
+			events: []data.Event{session(stick, 0, time.Minute), session(stick, time.Minute+time.Second, 2*time.Minute)},
+		},
+		{
+			name:   "after the window",
+			events: []data.Event{session(stick, 0, time.Minute), session(ducky, time.Minute+DefaultWindow+time.Second, 2*time.Minute)},
+		},
+		{
+			name:   "other port",
+			events: []data.Event{session(stick, 0, time.Minute), session(otherPort, time.Minute+time.Second, 2*time.Minute)},
+		},
+		{
+			name:   "other host",
+			events: []data.Event{session(stick, 0, time.Minute), session(otherHost, time.Minute+time.Second, 2*time.Minute)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings := reEnumerations(tt.events, DefaultWindow)
+
+			if tt.reason == "" {
+				if len(findings) != 0 {
+					t.Errorf("findings = %+v, want none", findings)
+				}
+				return
+			}
+			if len(findings) != 1 || findings[0].Rule != RuleReEnumeration || !strings.HasPrefix(findings[0].Reason, tt.reason) {
+				t.Fatalf("findings = %+v, want one starting with %q", findings, tt.reason)
+			}
+			if findings[0].Event.Vid != "03eb" {
+				t.Errorf("finding on %s:%s, want the second session", findings[0].Event.Vid, findings[0].Event.Pid)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package analysis
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/i582/cfmt/cmd/cfmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/olekukonko/tablewriter/renderer"
+	"github.com/pixfid/luft/core/utils"
+	"github.com/pixfid/luft/data"
+)
+
+// PrintFindings prints analysis findings as a table
+func PrintFindings(findings []data.Finding) {
+	config := renderer.ColorizedConfig{
+		Header: renderer.Tint{
+			FG: renderer.Colors{color.FgWhite, color.Bold},
+		},
+		Column: renderer.Tint{
+			FG: renderer.Colors{color.FgWhite},
+			Columns: []renderer.Tint{
+				{FG: renderer.Colors{color.FgGreen}},  // Connected time
+				{FG: renderer.Colors{color.FgWhite}},  // Host
+				{FG: renderer.Colors{color.FgWhite}},  // Port
+				{FG: renderer.Colors{color.FgWhite}},  // VID
+				{FG: renderer.Colors{color.FgWhite}},  // PID
+				{FG: renderer.Colors{color.FgWhite}},  // Product
+				{FG: renderer.Colors{color.FgCyan}},   // Interfaces
+				{FG: renderer.Colors{color.FgHiRed}},  // Rule
+				{FG: renderer.Colors{color.FgYellow}}, // Reason
+			},
+		},
+		Border: renderer.Tint{
+			FG: renderer.Colors{color.FgHiBlack},
+		},
+	}
+
+	table := tablewriter.NewTable(os.Stdout,
+		tablewriter.WithRenderer(renderer.NewColorized(config)),
+	)
+
+	table.Header("Connected", "Host", "Port", "VID", "PID", "Product", "Interfaces", "Rule", "Reason")
+
+	for _, finding := range findings {
+		event := finding.Event
+		table.Append(
+			utils.FormatConnectedTime(event),
+			event.Host,
+			event.ConnectionPort,
+			event.Vid,
+			event.Pid,
+			event.ProductName,
+			utils.FormatInterfaces(event),
+			finding.Rule,
+			finding.Reason,
+		)
+	}
+
+	table.Render()
+}
+
+// ExportFindings writes analysis findings to fileName in json or xml format
+func ExportFindings(findings []data.Finding, format string, fileName string) error {
+	var exportData []byte
+	var err error
+
+	fn := fmt.Sprintf("%s.%s", fileName, format)
+	switch format {
+	case "json":
+		exportData, err = json.MarshalIndent(findings, "", " ")
+	case "xml":
+		exportData, err = xml.MarshalIndent(findings, "", " ")
+	default:
+		return fmt.Errorf("unsupported export format for findings: %s (use json or xml)", format)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to marshal findings: %w", err)
+	}
+
+	if err := os.WriteFile(fn, exportData, fs.ModePerm); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", fn, err)
+	}
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Findings exported to: %s}}::green", time.Now().Format(time.Stamp), fn))
+
+	return nil
+}
+
+// Run runs the analysis selected in params on the collected events and reports
+// the findings
+func Run(params data.ParseParams, events []data.Event) error {
+	var findings []data.Finding
+
+	switch params.Analysis {
+	case "badusb":
+		window := params.AnalysisWindow
+		if window <= 0 {
+			window = DefaultWindow
+		}
+		findings = BadUSB(events, window)
+	default:
+		return fmt.Errorf("unknown analysis: %s", params.Analysis)
+	}
+
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Analysis %s: %d findings in %d devices}}::green",
+		time.Now().Format(time.Stamp), params.Analysis, len(findings), len(events)))
+
+	if params.Export {
+		return ExportFindings(findings, params.Format, params.FileName)
+	}
+
+	PrintFindings(findings)
+	return nil
+}
@@ -0,0 +1,319 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pixfid/luft/core/utils"
+	"github.com/pixfid/luft/data"
+	_ "modernc.org/sqlite"
+)
+
+// DefaultPath is the default location of the events database
+const DefaultPath = "~/.local/share/luft/luft.db"
+
+// schemaVersion is stored in PRAGMA user_version and bumped on schema changes
+const schemaVersion = 2
+
+// schema creates the tables used to keep scan history. Every event is stored as
+// JSON so new data.Event fields are persisted without schema changes; the
+// columns next to it exist for de-duplication and filtering.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS scans (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		source      TEXT NOT NULL,
+		host        TEXT NOT NULL,
+		log_path    TEXT NOT NULL,
+		files       INTEGER NOT NULL,
+		events      INTEGER NOT NULL,
+		started_at  TEXT NOT NULL,
+		finished_at TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS events (
+		id              INTEGER PRIMARY KEY AUTOINCREMENT,
+		scan_id         INTEGER NOT NULL REFERENCES scans(id),
+		scan_host       TEXT NOT NULL,
+		host            TEXT NOT NULL,
+		connected_time  TEXT NOT NULL,
+		vid             TEXT NOT NULL,
+		pid             TEXT NOT NULL,
+		serial_number   TEXT NOT NULL,
+		connection_port TEXT NOT NULL,
+		monotonic       INTEGER NOT NULL,
+		is_mass_storage INTEGER NOT NULL,
+		event           TEXT NOT NULL,
+		UNIQUE (scan_host, host, connected_time, monotonic, vid, pid, serial_number, connection_port)
+	)`,
+	`CREATE INDEX IF NOT EXISTS events_connected_time ON events (connected_time)`,
+	`CREATE INDEX IF NOT EXISTS events_serial_number ON events (serial_number)`,
+}
+
+// migration upgrades a database from the version before to version. prepare
+// runs before the schema is created and finish after it.
+type migration struct {
+	version         int
+	prepare, finish []string
+}
+
+var migrations = []migration{
+	{
+		// Events of dmesg-only logs have no connected_time, the kernel timestamp
+		// keeps the sessions of different boots apart
+		version: 2,
+		prepare: []string{
+			`ALTER TABLE events RENAME TO events_v1`,
+			`DROP INDEX events_connected_time`,
+			`DROP INDEX events_serial_number`,
+		},
+		finish: []string{
+			`INSERT INTO events (id, scan_id, scan_host, host, connected_time, vid, pid, serial_number, connection_port,
+				monotonic, is_mass_storage, event)
+				SELECT id, scan_id, scan_host, host, connected_time, vid, pid, serial_number, connection_port,
+				COALESCE(json_extract(event, '$.Monotonic'), 0), is_mass_storage, event
+				FROM events_v1`,
+			`DROP TABLE events_v1`,
+		},
+	},
+}
+
+// Scan describes a single local or remote scan stored in the database
+type Scan struct {
+	ID         int64
+	Source     string
+	Host       string
+	LogPath    string
+	Files      int
+	Events     int
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Store is a SQLite backed history of USB events
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens (and creates if needed) the events database at path
+func Open(path string) (*Store, error) {
+	if path == "" {
+		path = DefaultPath
+	}
+
+	path, err := utils.ExpandPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand database path: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	// Stores opened by other goroutines or processes may write at the same time:
+	// wait for their lock rather than fail, and let readers run beside a writer.
+	// Transactions take the write lock when they begin, a read lock can't be
+	// upgraded while another store writes.
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_txlock=immediate")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+
+	// SQLite allows a single writer, avoid "database is locked" between goroutines
+	db.SetMaxOpenConns(1)
+
+	store := &Store{db: db}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize database %s: %w", path, err)
+	}
+
+	return store, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) migrate() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version > schemaVersion {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", version, schemaVersion)
+	}
+
+	// A new database (version 0) is created with the current schema
+	var pending []migration
+	for _, m := range migrations {
+		if version > 0 && version < m.version {
+			pending = append(pending, m)
+		}
+	}
+
+	var stmts []string
+	for _, m := range pending {
+		stmts = append(stmts, m.prepare...)
+	}
+	stmts = append(stmts, schema...)
+	for _, m := range pending {
+		stmts = append(stmts, m.finish...)
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, schemaVersion)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SaveScan stores the scan metadata and its events in a single transaction.
+// Events already known from earlier scans of the same host are updated in place,
+// so re-scanning the same logs does not duplicate history.
+func (s *Store) SaveScan(scan Scan, events []data.Event) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO scans (source, host, log_path, files, events, started_at, finished_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		scan.Source, scan.Host, scan.LogPath, scan.Files, len(events),
+		formatTime(scan.StartedAt), formatTime(scan.FinishedAt))
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert scan: %w", err)
+	}
+
+	scanID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO events
+		(scan_id, scan_host, host, connected_time, monotonic, vid, pid, serial_number, connection_port, is_mass_storage, event)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (scan_host, host, connected_time, monotonic, vid, pid, serial_number, connection_port)
+		DO UPDATE SET scan_id = excluded.scan_id, is_mass_storage = excluded.is_mass_storage, event = excluded.event`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	for _, event := range events {
+		// Trust is evaluated against the whitelist at query time
+		event.Trusted = false
+		event.WhiteListRule = ""
+		event.WhiteListComment = ""
+		event.Ignored = false
+
+		encoded, err := json.Marshal(event)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode event: %w", err)
+		}
+
+		_, err = stmt.Exec(scanID, scan.Host, event.Host, formatTime(event.ConnectedTime), int64(event.Monotonic),
+			event.Vid, event.Pid, event.SerialNumber, event.ConnectionPort, event.IsMassStorage, string(encoded))
+		if err != nil {
+			return 0, fmt.Errorf("failed to insert event: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return scanID, nil
+}
+
+// Events returns stored events ordered by connection time
+func (s *Store) Events(onlyMass bool) ([]data.Event, error) {
+	query := `SELECT event FROM events`
+	if onlyMass {
+		query += ` WHERE is_mass_storage = 1`
+	}
+	// connected_time keeps the offset of the zone the event was logged in, so
+	// the rows are ordered in Go rather than as strings
+	query += ` ORDER BY id`
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []data.Event
+	for rows.Next() {
+		var encoded string
+		if err := rows.Scan(&encoded); err != nil {
+			return nil, err
+		}
+
+		var event data.Event
+		if err := json.Unmarshal([]byte(encoded), &event); err != nil {
+			return nil, fmt.Errorf("failed to decode stored event: %w", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].ConnectedTime.Before(events[j].ConnectedTime)
+	})
+	return events, nil
+}
+
+// Scans returns all stored scans, newest first
+func (s *Store) Scans() ([]Scan, error) {
+	rows, err := s.db.Query(`SELECT id, source, host, log_path, files, events, started_at, finished_at
+		FROM scans ORDER BY id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scans: %w", err)
+	}
+	defer rows.Close()
+
+	var scans []Scan
+	for rows.Next() {
+		var scan Scan
+		var startedAt, finishedAt string
+		if err := rows.Scan(&scan.ID, &scan.Source, &scan.Host, &scan.LogPath, &scan.Files, &scan.Events,
+			&startedAt, &finishedAt); err != nil {
+			return nil, err
+		}
+		scan.StartedAt = parseTime(startedAt)
+		scan.FinishedAt = parseTime(finishedAt)
+		scans = append(scans, scan)
+	}
+
+	return scans, rows.Err()
+}
+
+func formatTime(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
+
+func parseTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
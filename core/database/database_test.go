@@ -0,0 +1,326 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pixfid/luft/data"
+)
+
+// TestConcurrentSaveScan saves scans from stores opened on the same file at
+// once, as parallel scans of several hosts do
+func TestConcurrentSaveScan(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "luft.db")
+	const hosts = 16
+
+	var wg sync.WaitGroup
+	errs := make([]error, hosts)
+	for i := 0; i < hosts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store, err := Open(path)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer store.Close()
+
+			host := fmt.Sprintf("host-%02d", i)
+			_, errs[i] = store.SaveScan(Scan{Source: "remote", Host: host, StartedAt: time.Now(), FinishedAt: time.Now()}, []data.Event{
+				{Host: host, Vid: "0781", Pid: "5567", SerialNumber: "A", ConnectedTime: time.Date(2024, 1, 1, 10, i, 0, 0, time.UTC)},
+			})
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("host %d: %v", i, err)
+		}
+	}
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	scans, err := store.Scans()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := store.Events(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scans) != hosts || len(events) != hosts {
+		t.Errorf("got %d scans and %d events, want %d of each", len(scans), len(events), hosts)
+	}
+}
+
+// openTemp opens a new database in a test directory
+func openTemp(t *testing.T) *Store {
+	t.Helper()
+	store, err := Open(filepath.Join(t.TempDir(), "luft.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// TestSaveScanBoots stores the sessions of a device on the same port in two
+// boots, logged by dmesg only so without wall-clock time
+func TestSaveScanBoots(t *testing.T) {
+	store := openTemp(t)
+
+	first := data.Event{Vid: "0781", Pid: "5567", SerialNumber: "A", ConnectionPort: "1-2", Monotonic: 12 * time.Second}
+	second := first
+	second.Monotonic = 95 * time.Second
+
+	for _, event := range []data.Event{first, second, first} {
+		if _, err := store.SaveScan(Scan{Source: "local", Host: "ws-01"}, []data.Event{event}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := store.Events(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Errorf("got %d events, want one per boot: %+v", len(events), events)
+	}
+}
+
+// TestMigrateV1 opens a database created with the first schema, whose event key
+// had no kernel timestamp
+func TestMigrateV1(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "luft.db")
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE scans (
+			id INTEGER PRIMARY KEY AUTOINCREMENT, source TEXT NOT NULL, host TEXT NOT NULL, log_path TEXT NOT NULL,
+			files INTEGER NOT NULL, events INTEGER NOT NULL, started_at TEXT NOT NULL, finished_at TEXT NOT NULL)`,
+		`CREATE TABLE events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT, scan_id INTEGER NOT NULL REFERENCES scans(id), scan_host TEXT NOT NULL,
+			host TEXT NOT NULL, connected_time TEXT NOT NULL, vid TEXT NOT NULL, pid TEXT NOT NULL,
+			serial_number TEXT NOT NULL, connection_port TEXT NOT NULL, is_mass_storage INTEGER NOT NULL, event TEXT NOT NULL,
+			UNIQUE (scan_host, host, connected_time, vid, pid, serial_number, connection_port))`,
+		`CREATE INDEX events_connected_time ON events (connected_time)`,
+		`CREATE INDEX events_serial_number ON events (serial_number)`,
+		`INSERT INTO scans VALUES (1, 'local', 'ws-01', '/var/log', 1, 1, '2024-01-01T10:00:00Z', '2024-01-01T10:00:01Z')`,
+		`INSERT INTO events VALUES (1, 1, 'ws-01', '', '0001-01-01T00:00:00Z', '0781', '5567', 'A', '1-2', 1,
+			'{"Vid":"0781","Pid":"5567","SerialNumber":"A","ConnectionPort":"1-2","Monotonic":12000000000}')`,
+		`PRAGMA user_version = 1`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var version int
+	var monotonic int64
+	if err := store.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.db.QueryRow(`SELECT monotonic FROM events WHERE id = 1`).Scan(&monotonic); err != nil {
+		t.Fatal(err)
+	}
+	if version != schemaVersion || monotonic != int64(12*time.Second) {
+		t.Errorf("version %d, monotonic %d, want %d and the kernel timestamp of the stored event", version, monotonic, schemaVersion)
+	}
+
+	// The same session updates the migrated row, a session of another boot is added
+	event := data.Event{Vid: "0781", Pid: "5567", SerialNumber: "A", ConnectionPort: "1-2", Monotonic: 12 * time.Second, IsMassStorage: true}
+	later := event
+	later.Monotonic = 95 * time.Second
+	if _, err := store.SaveScan(Scan{Source: "local", Host: "ws-01"}, []data.Event{event, later}); err != nil {
+		t.Fatal(err)
+	}
+	events, err := store.Events(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Errorf("got %d events, want 2: %+v", len(events), events)
+	}
+	for _, index := range []string{"events_connected_time", "events_serial_number"} {
+		var name string
+		if err := store.db.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'index' AND name = ?`, index).Scan(&name); err != nil {
+			t.Errorf("index %s: %v", index, err)
+		}
+	}
+}
+
+func TestSaveScanRoundTrip(t *testing.T) {
+	store := openTemp(t)
+
+	connected := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	event := data.Event{
+		ID:                "a1b2c3d4",
+		Host:              "ws-01",
+		Vid:               "0781",
+		Pid:               "5567",
+		SerialNumber:      "4C530001",
+		ProductName:       "Cruzer Blade",
+		ConnectionPort:    "1-2",
+		ConnectedTime:     connected,
+		DisconnectionTime: connected.Add(time.Minute),
+		Duration:          time.Minute,
+		IsMassStorage:     true,
+		Users:             []string{"alice"},
+		Evidence:          []data.Evidence{{Source: "/var/log/kern.log", Line: 3, Offset: 120, LogLine: "usb 1-2: new high-speed USB device"}},
+		// Trust is evaluated against the whitelist at query time
+		Trusted:          true,
+		WhiteListRule:    "backup stick",
+		WhiteListComment: "kept in the safe",
+		Ignored:          true,
+	}
+	keyboard := data.Event{Host: "ws-01", Vid: "046d", Pid: "c31c", ConnectionPort: "1-3", ConnectedTime: connected.Add(time.Hour)}
+
+	startedAt := time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC)
+	scan := Scan{Source: "local", Host: "ws-01", LogPath: "/var/log", Files: 3, StartedAt: startedAt, FinishedAt: startedAt.Add(time.Second)}
+	scanID, err := store.SaveScan(scan, []data.Event{event, keyboard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scans, err := store.Scans()
+	if err != nil {
+		t.Fatal(err)
+	}
+	scan.ID, scan.Events = scanID, 2
+	if len(scans) != 1 || !reflect.DeepEqual(scans[0], scan) {
+		t.Errorf("scans = %+v, want %+v", scans, scan)
+	}
+
+	events, err := store.Events(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := event
+	want.Trusted, want.WhiteListRule, want.WhiteListComment, want.Ignored = false, "", "", false
+	if len(events) != 2 || !reflect.DeepEqual(events[0], want) {
+		t.Errorf("events[0] = %+v, want %+v", events[0], want)
+	}
+
+	massStorage, err := store.Events(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(massStorage) != 1 || massStorage[0].SerialNumber != "4C530001" {
+		t.Errorf("mass storage events = %+v, want the stick only", massStorage)
+	}
+}
+
+// TestSaveScanConflict re-scans logs holding a known session with more detail
+func TestSaveScanConflict(t *testing.T) {
+	store := openTemp(t)
+
+	connected := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	event := data.Event{Host: "ws-01", Vid: "0781", Pid: "5567", SerialNumber: "A", ConnectionPort: "1-2", ConnectedTime: connected}
+	if _, err := store.SaveScan(Scan{Source: "local", Host: "ws-01"}, []data.Event{event}); err != nil {
+		t.Fatal(err)
+	}
+
+	event.IsMassStorage = true
+	event.Duration = time.Minute
+	other := event
+	other.ConnectionPort = "1-3"
+	secondScan, err := store.SaveScan(Scan{Source: "local", Host: "ws-01"}, []data.Event{event, other})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same session scanned from another host is kept apart
+	if _, err := store.SaveScan(Scan{Source: "remote", Host: "collector"}, []data.Event{event}); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := store.Events(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3: %+v", len(events), events)
+	}
+
+	var scanID int64
+	var massStorage bool
+	if err := store.db.QueryRow(`SELECT scan_id, is_mass_storage FROM events WHERE scan_host = 'ws-01' AND connection_port = '1-2'`).
+		Scan(&scanID, &massStorage); err != nil {
+		t.Fatal(err)
+	}
+	if scanID != secondScan || !massStorage {
+		t.Errorf("updated row has scan %d and mass storage %v, want scan %d and true", scanID, massStorage, secondScan)
+	}
+	if events[0].Duration != time.Minute {
+		t.Errorf("stored event = %+v, want the one of the latest scan", events[0])
+	}
+}
+
+// TestEventsOrder stores events logged in different zones, whose RFC 3339
+// strings don't sort chronologically
+func TestEventsOrder(t *testing.T) {
+	store := openTemp(t)
+
+	tokyo := time.FixedZone("JST", 9*3600)
+	newYork := time.FixedZone("EST", -5*3600)
+	events := []data.Event{
+		// 2024-01-01T14:00:00Z
+		{SerialNumber: "third", ConnectedTime: time.Date(2024, 1, 1, 9, 0, 0, 0, newYork)},
+		// 2024-01-01T01:00:00Z
+		{SerialNumber: "first", ConnectedTime: time.Date(2024, 1, 1, 10, 0, 0, 0, tokyo)},
+		// 2024-01-01T05:00:00Z
+		{SerialNumber: "second", ConnectedTime: time.Date(2024, 1, 1, 5, 0, 0, 0, time.UTC)},
+	}
+	if _, err := store.SaveScan(Scan{Source: "local", Host: "ws-01"}, events); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := store.Events(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, event := range stored {
+		order = append(order, event.SerialNumber)
+	}
+	if want := []string{"first", "second", "third"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("order = %q, want %q", order, want)
+	}
+}
+
+func TestOpenNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "luft.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, schemaVersion+1)); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if store, err := Open(path); err == nil {
+		store.Close()
+		t.Error("Open succeeded on a database of a newer version")
+	}
+}
@@ -0,0 +1,240 @@
+package parsers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/i582/cfmt/cmd/cfmt"
+	"github.com/pixfid/luft/core/utils"
+	"github.com/pixfid/luft/data"
+)
+
+var (
+	zipMagic = []byte("PK\x03\x04")
+	// ustar magic of POSIX and GNU tar headers, at tarMagicOffset
+	tarMagic = []byte("ustar")
+)
+
+const tarMagicOffset = 257
+
+// archiveMember is a regular file of a tar or zip archive
+type archiveMember struct {
+	name    string
+	modTime time.Time
+	size    int64
+	open    func() (io.ReadCloser, error)
+}
+
+// archiveLogs is what was read from the members of an evidence archive. The
+// sources of the logs are the archive path and member name, archive:member.
+type archiveLogs struct {
+	LogFiles
+	records  []data.LogEvent
+	sessions []data.Session
+}
+
+// IsArchive reports whether the local file at path is a zip archive or a tar
+// archive, compressed or not
+func IsArchive(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	buffered := bufio.NewReader(file)
+	if head, _ := buffered.Peek(len(zipMagic)); bytes.Equal(head, zipMagic) {
+		return true
+	}
+
+	reader, _, err := utils.Decompress(buffered)
+	if err != nil {
+		return false
+	}
+	defer reader.Close()
+
+	head, _ := bufio.NewReader(reader).Peek(tarMagicOffset + len(tarMagic))
+	return len(head) == tarMagicOffset+len(tarMagic) && bytes.Equal(head[tarMagicOffset:], tarMagic)
+}
+
+// walkArchive calls fn for every regular file of the tar or zip archive at
+// path, in archive order. Tar members are read in-stream, one at a time: a
+// member can't be read once fn has returned.
+func walkArchive(path string, fn func(member archiveMember) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	head := make([]byte, len(zipMagic))
+	if n, _ := io.ReadFull(file, head); bytes.Equal(head[:n], zipMagic) {
+		return walkZip(file, fn)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader, _, err := utils.Decompress(file)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		err = fn(archiveMember{
+			name:    header.Name,
+			modTime: header.ModTime,
+			size:    header.Size,
+			open: func() (io.ReadCloser, error) {
+				return io.NopCloser(tr), nil
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// walkZip calls fn for every regular file of the zip archive file
+func walkZip(file *os.File, fn func(member archiveMember) error) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	zr, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return fmt.Errorf("failed to read zip archive: %w", err)
+	}
+
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		err := fn(archiveMember{
+			name:    f.Name,
+			modTime: f.Modified,
+			size:    int64(f.UncompressedSize64),
+			open:    f.Open,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readArchive selects the members of the archive at archivePath like the files
+// of a log directory and, unless dryRun is set, parses them in one pass without
+// extracting them. Compressed members are decompressed by their magic bytes.
+func readArchive(ctx context.Context, archivePath string, selector LogSelector, dryRun bool) (archiveLogs, error) {
+	logs := archiveLogs{LogFiles: LogFiles{ModTimes: make(map[string]time.Time)}}
+	decide := func(source string, parsed bool, reason string, args ...any) {
+		logs.Decisions = append(logs.Decisions, LogFileDecision{Path: source, Parsed: parsed, Reason: fmt.Sprintf(reason, args...)})
+	}
+
+	err := walkArchive(archivePath, func(member archiveMember) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel := strings.TrimPrefix(path.Clean("/"+member.name), "/")
+		source := archivePath + ":" + rel
+
+		kind, reason := selector.classify(rel)
+		switch {
+		case kind == unmatchedFile:
+			logs.Unmatched++
+			return nil
+		case kind == excludedFile:
+			decide(source, false, "%s", reason)
+			return nil
+		case member.size == 0:
+			decide(source, false, "empty file")
+			return nil
+		case kind == logFile && IsJournalFile(rel):
+			decide(source, false, "journal files are not read from archives")
+			return nil
+		}
+
+		file, err := member.open()
+		if err != nil {
+			decide(source, false, "can't be read: %s", err.Error())
+			return nil
+		}
+		defer file.Close()
+
+		reader, _, err := utils.Decompress(file)
+		if err != nil {
+			decide(source, false, "can't be read: %s", err.Error())
+			return nil
+		}
+		defer reader.Close()
+
+		if kind == loginFile {
+			logs.LoginFiles = append(logs.LoginFiles, source)
+			decide(source, true, "%s", reason)
+			if dryRun {
+				return nil
+			}
+
+			records, err := ReadLoginRecords(reader, rel)
+			if err != nil {
+				_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: %s: %s}}::yellow", time.Now().Format(time.Stamp), source, err.Error()))
+			}
+			logs.sessions = append(logs.sessions, records...)
+			return nil
+		}
+
+		buffered := bufio.NewReader(reader)
+		if skipped := sniffHead(buffered); skipped != "" {
+			decide(source, false, "%s", skipped)
+			return nil
+		}
+
+		logs.Logs = append(logs.Logs, source)
+		logs.ModTimes[source] = member.modTime
+		decide(source, true, "%s", reason)
+		if dryRun {
+			return nil
+		}
+
+		scanner := newLineScanner(buffered)
+		logs.records = append(logs.records, parseLine(scanner, source)...)
+		if err := scanner.Err(); err != nil {
+			_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: scanner error for %s: %s}}::yellow", time.Now().Format(time.Stamp), source, err.Error()))
+		}
+		return nil
+	})
+	if err != nil {
+		return logs, fmt.Errorf("failed to read archive %s: %w", archivePath, err)
+	}
+
+	if len(logs.Logs) == 0 && len(logs.LoginFiles) == 0 && !dryRun {
+		return logs, fmt.Errorf("no log files found in %s", archivePath)
+	}
+
+	return logs, nil
+}
@@ -0,0 +1,135 @@
+package parsers
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIsArchive(t *testing.T) {
+	tests := []struct {
+		file string
+		want bool
+	}{
+		{"varlog.tar.gz", true},
+		{"varlog.zip", true},
+		{"wtmp.tar.gz", true},
+		{"varlog/kern.log", false},
+		// A compressed log is not an archive
+		{"varlog/syslog.2.gz", false},
+		{"varlog/dmesg", false},
+		{"varlog", false},
+		{"missing.tar.gz", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			if got := IsArchive(filepath.Join("testdata", tt.file)); got != tt.want {
+				t.Errorf("IsArchive(%q) = %v, want %v", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestReadArchive reads testdata/varlog archived with tar and zip, the zip
+// members under var/log/
+func TestReadArchive(t *testing.T) {
+	tests := []struct {
+		archive   string
+		prefix    string
+		unmatched int
+		dryRun    bool
+		records   int
+		sessions  int
+	}{
+		{archive: "varlog.tar.gz", unmatched: 1, records: 4, sessions: 2},
+		{archive: "varlog.zip", prefix: "var/log/", unmatched: 1, records: 4, sessions: 2},
+		// Nothing is parsed in a dry run
+		{archive: "varlog.tar.gz", unmatched: 1, dryRun: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.archive, func(t *testing.T) {
+			path := filepath.Join("testdata", tt.archive)
+			selector, err := NewLogSelector(nil, []string{"installer"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			logs, err := readArchive(context.Background(), path, selector, tt.dryRun)
+			if err != nil {
+				t.Fatalf("readArchive: %v", err)
+			}
+
+			at := func(rel string) string { return path + ":" + tt.prefix + rel }
+			if want := []string{at("kern.log"), at("syslog.2.gz")}; !reflect.DeepEqual(logs.Logs, want) {
+				t.Errorf("Logs = %q, want %q", logs.Logs, want)
+			}
+			if want := []string{at("wtmp")}; !reflect.DeepEqual(logs.LoginFiles, want) {
+				t.Errorf("LoginFiles = %q, want %q", logs.LoginFiles, want)
+			}
+			if logs.Unmatched != tt.unmatched {
+				t.Errorf("Unmatched = %d, want %d", logs.Unmatched, tt.unmatched)
+			}
+
+			parsed := make(map[string]bool)
+			for _, decision := range logs.Decisions {
+				parsed[decision.Path] = decision.Parsed
+			}
+			want := map[string]bool{
+				at("dmesg"): false, at("installer/syslog"): false, at("kern.log"): true,
+				at("syslog.1"): false, at("syslog.2.gz"): true, at("wtmp"): true,
+			}
+			if !reflect.DeepEqual(parsed, want) {
+				t.Errorf("decisions = %+v, want parsed %v", logs.Decisions, want)
+			}
+
+			if len(logs.records) != tt.records {
+				t.Errorf("got %d records, want %d", len(logs.records), tt.records)
+			}
+			for _, record := range logs.records {
+				if record.Source != at("kern.log") && record.Source != at("syslog.2.gz") {
+					t.Errorf("record from %q", record.Source)
+				}
+			}
+			if len(logs.sessions) != tt.sessions {
+				t.Errorf("got %d sessions, want %d", len(logs.sessions), tt.sessions)
+			}
+		})
+	}
+}
+
+// TestReadArchiveLoginRecordsOnly checks that an archive holding only wtmp and
+// btmp files is read rather than reported empty
+func TestReadArchiveLoginRecordsOnly(t *testing.T) {
+	path := filepath.Join("testdata", "wtmp.tar.gz")
+	selector, err := NewLogSelector(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs, err := readArchive(context.Background(), path, selector, false)
+	if err != nil {
+		t.Fatalf("readArchive: %v", err)
+	}
+	if len(logs.Logs) != 0 || len(logs.LoginFiles) != 2 {
+		t.Errorf("Logs = %q, LoginFiles = %q, want only the wtmp and btmp files", logs.Logs, logs.LoginFiles)
+	}
+	// Two wtmp sessions and a failed login
+	if len(logs.sessions) != 3 {
+		t.Errorf("got %d sessions, want 3", len(logs.sessions))
+	}
+}
+
+func TestReadArchiveNoLogs(t *testing.T) {
+	selector, err := NewLogSelector([]string{"auth.log"}, []string{"wtmp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join("testdata", "varlog.zip")
+
+	if _, err := readArchive(context.Background(), path, selector, false); err == nil {
+		t.Error("readArchive succeeded on an archive with nothing to read")
+	}
+}
@@ -0,0 +1,503 @@
+package parsers
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pixfid/luft/core/utils"
+	"github.com/pixfid/luft/data"
+)
+
+// CollectEventsData regexes
+var (
+	reVid  = regexp.MustCompile(`idVendor=(\w+)`)
+	rePid  = regexp.MustCompile(`idProduct=(\w+)`)
+	reHost = regexp.MustCompile(`(.*:\d{2}\s)(.*) (.*:\s\[)`)
+
+	// Bus-port path of usb and usb-storage lines: "usb 1-1.2: ...", "usb-storage 2-3:1.0: ...",
+	// "usb usb1: ..." for root hubs. The interface suffix (:1.0) is split off so interface
+	// lines map to their device.
+	rePortPath = regexp.MustCompile(`(?:^|[\]:-] )usb(?:-storage)? (usb\d+|\d+-[\d.]+)(?::\d+\.(\d+))?: (.*)$`)
+
+	// "new high-speed USB device number 5 using xhci_hcd"
+	reEnumeration = regexp.MustCompile(`new (.+?) USB device number (\d+) using (\S+)`)
+	reBcdDevice   = regexp.MustCompile(`bcdDevice=\s*([\d.]+)`)
+
+	// Interface driver binds: "cdc_acm 1-3:1.0: ttyACM0: USB ACM device",
+	// "cdc_ether 2-1:1.0 usb0: register 'cdc_ether' ...", "hub 1-1:1.0: USB hub found"
+	reDriverBind = regexp.MustCompile(`(?:^|[\]:-] )([a-z][\w-]*) (\d+-[\d.]+):\d+\.(\d+)(?::| \w+:) `)
+
+	// "input: Logitech USB Keyboard as /devices/pci0000:00/0000:00:14.0/usb1/1-2/1-2:1.0/0003:046D:C31C.0001/input/input5"
+	reHIDInput = regexp.MustCompile(`input: .* as /devices/\S*/(\d+-[\d.]+):\d+\.(\d+)/[0-9A-Fa-f]{4}:`)
+
+	// "hid-generic 0003:046D:C31C.0001: input,hidraw0: USB HID v1.10 Keyboard [Logitech USB Keyboard] on usb-0000:00:14.0-2/input0"
+	reHIDDevice = regexp.MustCompile(`(?:^|[\]:-] )([a-z][\w-]*) 0003:([0-9A-Fa-f]{4}):([0-9A-Fa-f]{4})\.[0-9A-Fa-f]+: .*USB HID v[\d.]+ (\w+) \[.*\] on usb-\S+/input(\d+)`)
+
+	// "scsi host6: uas", "scsi host7: usb-storage 1-3:1.0"
+	reSCSIHost = regexp.MustCompile(`scsi host(\d+): (?:usb-storage (\d+-[\d.]+):\d+\.\d+|uas)$`)
+
+	// "sd 7:0:0:0: [sdb] 30253056 512-byte logical blocks: (15.5 GB/14.4 GiB)"
+	reSCSIDisk     = regexp.MustCompile(`sd (\d+):\d+:\d+:\d+: \[(sd[a-z]+)\] `)
+	reDiskCapacity = regexp.MustCompile(`\d+ \d+-byte logical blocks: \(([^/)]+)`)
+
+	// " sdb: sdb1 sdb2", " sdc: sdc1 < sdc5 sdc6 >"
+	rePartitions = regexp.MustCompile(`(?:^|[\]:] )\s*(sd[a-z]+):((?:\s+(?:<|>|sd[a-z]+\d+))+)\s*$`)
+	rePartition  = regexp.MustCompile(`sd[a-z]+\d+`)
+
+	// "EXT4-fs (sdb1): mounted filesystem ...", "FAT-fs (sdb1): Volume was not properly unmounted"
+	reKernelFS = regexp.MustCompile(`(?:^|[\]:] )(\w+)-fs \(((sd[a-z]+)\d*)\): `)
+	// "BTRFS: device label backup devid 1 transid 12 /dev/sdb1"
+	reBtrfsLabel = regexp.MustCompile(`BTRFS: device label (.+?) devid \d+ transid \d+ /dev/((sd[a-z]+)\d*)`)
+	// udisksd: "Mounted /dev/sdb1 at /media/user/KINGSTON on behalf of uid 1000"
+	reUdisksMount = regexp.MustCompile(`Mounted /dev/((sd[a-z]+)\d*) at (.+?)(?: on behalf of uid \d+)?\.?$`)
+	// udisks mount points are named after the filesystem label, or the UUID
+	// for filesystems without one
+	reMediaLabel = regexp.MustCompile(`^(?:/run)?/media/[^/]+/(.+)$`)
+	reUUID       = regexp.MustCompile(`^[0-9A-Fa-f]{4,}(?:-[0-9A-Fa-f]{4,})+$`)
+)
+
+// kernelFilesystems maps kernel "<name>-fs" prefixes to filesystem types
+var kernelFilesystems = map[string]string{
+	"FAT":   "vfat",
+	"exFAT": "exfat",
+}
+
+// driverClasses maps interface drivers to the device class they imply
+var driverClasses = map[string]string{
+	"usbhid":        "HID",
+	"usb-storage":   "Mass Storage",
+	"uas":           "Mass Storage",
+	"cdc_acm":       "Serial",
+	"ftdi_sio":      "Serial",
+	"pl2303":        "Serial",
+	"cp210x":        "Serial",
+	"ch341":         "Serial",
+	"option":        "Serial",
+	"cdc_ether":     "Network",
+	"cdc_ncm":       "Network",
+	"rndis_host":    "Network",
+	"r8152":         "Network",
+	"ax88179_178a":  "Network",
+	"asix":          "Network",
+	"uvcvideo":      "Video",
+	"snd-usb-audio": "Audio",
+	"btusb":         "Bluetooth",
+	"usblp":         "Printer",
+	"hub":           "Hub",
+}
+
+// classifyLine returns the action type of a log line that CollectEventsData or
+// CollectSessions can use, or data.Unknown for unrelated lines
+func classifyLine(logLine string) data.ActionType {
+	switch {
+	case reUSB.MatchString(logLine) || reUSBStorage.MatchString(logLine):
+		return utils.GetActionType(logLine)
+	case reDriverBind.MatchString(logLine), reHIDInput.MatchString(logLine),
+		reHIDDevice.MatchString(logLine), reSCSIHost.MatchString(logLine):
+		return data.Connected
+	case reSCSIDisk.MatchString(logLine), rePartitions.MatchString(logLine), reKernelFS.MatchString(logLine),
+		reBtrfsLabel.MatchString(logLine), reUdisksMount.MatchString(logLine):
+		return data.Connected
+	}
+	return classifySessionLine(logLine)
+}
+
+// nameKey identifies a SCSI host or block device name on a host
+type nameKey struct {
+	host string
+	name string
+}
+
+// storageIndex maps SCSI hosts and block devices to the collected device they belong to
+type storageIndex struct {
+	scsiHosts map[nameKey]int
+	disks     map[nameKey]int
+}
+
+// enumeration holds the "new ... USB device number N using ..." line of a port
+// until the device descriptor is logged
+type enumeration struct {
+	speed      string
+	number     string
+	controller string
+	line       data.LogEvent
+}
+
+// portKey identifies a physical USB port on a host
+type portKey struct {
+	host string
+	port string
+}
+
+// logEventHost returns the host name of a log line
+func logEventHost(event data.LogEvent) string {
+	if event.Host != "" {
+		return event.Host
+	}
+	return utils.Submatch(reHost, event.LogLine, 2)
+}
+
+// orderLogEvents returns events in chronological order with lines logged to several
+// files (syslog and kern.log, messages) kept once, so sessions spanning a log
+// rotation can be paired
+func orderLogEvents(events []data.LogEvent) []data.LogEvent {
+	type lineKey struct {
+		date time.Time
+		line string
+	}
+
+	ordered := make([]data.LogEvent, 0, len(events))
+	seen := make(map[lineKey]bool, len(events))
+	for _, event := range events {
+		key := lineKey{date: event.Date.UTC(), line: event.LogLine}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		ordered = append(ordered, event)
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Date.Before(ordered[j].Date)
+	})
+
+	return ordered
+}
+
+// CollectEventsData collect data from events logs.
+//
+// Attribute lines are attached to the device by the bus-port path of the line, so
+// devices enumerating at the same time (a hub with several devices, a boot-time
+// burst) keep their own Product/Manufacturer/SerialNumber regardless of the order
+// the lines were logged in. A disconnect closes only the session currently open on
+// its port. A session without a logged disconnect stays open, unless its port
+// enumerates another device: it is then closed at that time, marked inferred.
+//
+// Enumeration lines give the speed, device number and host controller, and driver
+// bind lines the interfaces of the device, so a keyboard can be told apart from a
+// network adapter or a storage stick with the same descriptor strings. Storage
+// devices are followed through their SCSI host to the sdX disk, its partitions and
+// the filesystems and mount points logged for them.
+//
+// Every line used for a device is kept as its evidence, with the file, line number
+// and offset it was read from.
+func CollectEventsData(events []data.LogEvent) []data.Event {
+	allEvents := make([]data.Event, 0)
+
+	// Index in allEvents of the open session on each port
+	attached := make(map[portKey]int)
+	// Enumerations waiting for their "New USB device found" line
+	pending := make(map[portKey]enumeration)
+	// Index in allEvents of the most recently enumerated device on each host
+	latest := make(map[string]int)
+	storage := storageIndex{scsiHosts: make(map[nameKey]int), disks: make(map[nameKey]int)}
+
+	for _, event := range orderLogEvents(events) {
+		match := rePortPath.FindStringSubmatch(event.LogLine)
+		if match == nil {
+			if event.ActionType == data.Connected && !collectInterface(allEvents, attached, event) {
+				collectStorage(allEvents, attached, latest, storage, event)
+			}
+			continue
+		}
+		key := portKey{host: logEventHost(event), port: match[1]}
+		message := match[3]
+
+		switch event.ActionType {
+		case data.Connected:
+			// Check for new USB device connection
+			if m := reEnumeration.FindStringSubmatch(message); m != nil {
+				pending[key] = enumeration{speed: m[1], number: m[2], controller: m[3], line: event}
+				continue
+			}
+
+			if strings.HasPrefix(message, "New USB device found, ") {
+				enum := pending[key]
+				delete(pending, key)
+
+				// No disconnect was logged for the device the port held (hub
+				// reset, lost lines, rotation gap): it was gone by now
+				if idx, ok := attached[key]; ok {
+					inferDisconnect(&allEvents[idx], event)
+				}
+
+				allEvents = append(allEvents, data.Event{
+					ConnectedTime:    event.Date,
+					Host:             key.host,
+					Vid:              utils.Submatch(reVid, message, 1),
+					Pid:              utils.Submatch(rePid, message, 1),
+					ProductName:      "None",
+					ManufacturerName: "None",
+					SerialNumber:     "None",
+					ConnectionPort:   key.port,
+					SessionOpen:      true,
+					Monotonic:        event.Monotonic,
+					Speed:            enum.speed,
+					DeviceNumber:     enum.number,
+					BcdDevice:        utils.Submatch(reBcdDevice, message, 1),
+					Controller:       enum.controller,
+				})
+				device := &allEvents[len(allEvents)-1]
+				if enum.line.LogLine != "" {
+					addEvidence(device, enum.line)
+				}
+				addEvidence(device, event)
+				attached[key] = len(allEvents) - 1
+				latest[key.host] = len(allEvents) - 1
+				continue
+			}
+
+			idx, ok := attached[key]
+			if !ok {
+				// Attribute line of a device enumerated before the logs start
+				continue
+			}
+			device := &allEvents[idx]
+			addEvidence(device, event)
+
+			switch {
+			case strings.HasPrefix(message, "Product: "):
+				device.ProductName = strings.TrimPrefix(message, "Product: ")
+			case strings.HasPrefix(message, "Manufacturer: "):
+				device.ManufacturerName = strings.TrimPrefix(message, "Manufacturer: ")
+			case strings.HasPrefix(message, "SerialNumber: "):
+				device.SerialNumber = strings.TrimPrefix(message, "SerialNumber: ")
+			case strings.HasPrefix(message, "USB Mass Storage device detected"):
+				device.IsMassStorage = true
+				addInterface(device, data.Interface{Number: match[2], Driver: "usb-storage", Class: driverClasses["usb-storage"]})
+			}
+
+		case data.Disconnected:
+			idx, ok := attached[key]
+			if !ok {
+				// Device connected before the logs start
+				continue
+			}
+			closeSession(&allEvents[idx], event)
+			delete(attached, key)
+		}
+	}
+
+	for i := range allEvents {
+		allEvents[i].ID = utils.EventID(allEvents[i])
+	}
+	return allEvents
+}
+
+// collectInterface attaches interface driver binds logged by other drivers than
+// usb and usb-storage to the device they belong to. It reports whether the line was
+// an interface line.
+func collectInterface(devices []data.Event, attached map[portKey]int, event data.LogEvent) bool {
+	host := logEventHost(event)
+
+	if m := reDriverBind.FindStringSubmatch(event.LogLine); m != nil && m[1] != "usb" {
+		idx, ok := attached[portKey{host: host, port: m[2]}]
+		if ok {
+			addInterface(&devices[idx], data.Interface{Number: m[3], Driver: m[1], Class: driverClasses[m[1]]})
+			addEvidence(&devices[idx], event)
+		}
+		return true
+	}
+
+	if m := reHIDInput.FindStringSubmatch(event.LogLine); m != nil {
+		idx, ok := attached[portKey{host: host, port: m[1]}]
+		if ok {
+			setHIDInterface(&devices[idx], m[2], "usbhid", driverClasses["usbhid"])
+			addEvidence(&devices[idx], event)
+		}
+		return true
+	}
+
+	if m := reHIDDevice.FindStringSubmatch(event.LogLine); m != nil {
+		// HID lines name the device by VID:PID only, pick the newest open session
+		idx := -1
+		for key, i := range attached {
+			device := devices[i]
+			if key.host == host && strings.EqualFold(device.Vid, m[2]) && strings.EqualFold(device.Pid, m[3]) && i > idx {
+				idx = i
+			}
+		}
+		if idx < 0 {
+			return true
+		}
+		class := "HID"
+		if m[4] != "Device" {
+			class = "HID " + m[4]
+		}
+		setHIDInterface(&devices[idx], m[5], m[1], class)
+		addEvidence(&devices[idx], event)
+		return true
+	}
+
+	return false
+}
+
+// collectStorage follows a storage device from its SCSI host to the block device,
+// partitions, filesystems and mount points
+func collectStorage(devices []data.Event, attached map[portKey]int, latest map[string]int, storage storageIndex, event data.LogEvent) {
+	host := logEventHost(event)
+
+	// openDisk returns the open session using a block device
+	openDisk := func(disk string) (*data.Event, bool) {
+		idx, ok := storage.disks[nameKey{host: host, name: disk}]
+		if !ok || !devices[idx].SessionOpen {
+			return nil, false
+		}
+		return &devices[idx], true
+	}
+
+	if m := reSCSIHost.FindStringSubmatch(event.LogLine); m != nil {
+		var idx int
+		var ok bool
+		if m[2] != "" {
+			idx, ok = attached[portKey{host: host, port: m[2]}]
+		} else {
+			// The uas line names no port, it follows the enumeration of the device
+			idx, ok = latest[host]
+		}
+		if !ok || !devices[idx].SessionOpen {
+			return
+		}
+		device := &devices[idx]
+		addEvidence(device, event)
+		device.IsMassStorage = true
+		device.SCSIHost = m[1]
+		storage.scsiHosts[nameKey{host: host, name: m[1]}] = idx
+		if m[2] == "" {
+			addInterface(device, data.Interface{Driver: "uas", Class: driverClasses["uas"]})
+		}
+		return
+	}
+
+	if m := reSCSIDisk.FindStringSubmatch(event.LogLine); m != nil {
+		idx, ok := storage.scsiHosts[nameKey{host: host, name: m[1]}]
+		if !ok || !devices[idx].SessionOpen {
+			return
+		}
+		device := &devices[idx]
+		addEvidence(device, event)
+		device.BlockDevice = m[2]
+		storage.disks[nameKey{host: host, name: m[2]}] = idx
+		if capacity := utils.Submatch(reDiskCapacity, event.LogLine, 1); capacity != "" {
+			device.Capacity = capacity
+		}
+		return
+	}
+
+	if m := rePartitions.FindStringSubmatch(event.LogLine); m != nil {
+		if device, ok := openDisk(m[1]); ok {
+			addEvidence(device, event)
+			device.Partitions = rePartition.FindAllString(m[2], -1)
+		}
+		return
+	}
+
+	if m := reKernelFS.FindStringSubmatch(event.LogLine); m != nil {
+		if device, ok := openDisk(m[3]); ok {
+			addEvidence(device, event)
+			filesystem, known := kernelFilesystems[m[1]]
+			if !known {
+				filesystem = strings.ToLower(m[1])
+			}
+			volume(device, m[2]).Filesystem = filesystem
+		}
+		return
+	}
+
+	if m := reBtrfsLabel.FindStringSubmatch(event.LogLine); m != nil {
+		if device, ok := openDisk(m[3]); ok {
+			addEvidence(device, event)
+			vol := volume(device, m[2])
+			vol.Filesystem = "btrfs"
+			vol.Label = m[1]
+		}
+		return
+	}
+
+	if m := reUdisksMount.FindStringSubmatch(event.LogLine); m != nil {
+		if device, ok := openDisk(m[2]); ok {
+			addEvidence(device, event)
+			vol := volume(device, m[1])
+			vol.MountPoint = m[3]
+			if label := utils.Submatch(reMediaLabel, m[3], 1); label != "" && vol.Label == "" && !reUUID.MatchString(label) {
+				vol.Label = label
+			}
+		}
+	}
+}
+
+// volume returns the volume of a device on the block device name, adding it if needed
+func volume(device *data.Event, name string) *data.Volume {
+	for i := range device.Volumes {
+		if device.Volumes[i].Device == name {
+			return &device.Volumes[i]
+		}
+	}
+	device.Volumes = append(device.Volumes, data.Volume{Device: name})
+	return &device.Volumes[len(device.Volumes)-1]
+}
+
+// addInterface records an interface of a device once per interface number and driver
+func addInterface(device *data.Event, iface data.Interface) {
+	for _, known := range device.Interfaces {
+		if known.Number == iface.Number && known.Driver == iface.Driver {
+			return
+		}
+	}
+	device.Interfaces = append(device.Interfaces, iface)
+}
+
+// setHIDInterface records a HID interface. The input and hid-generic lines of one
+// interface are merged, the more specific class (HID Keyboard, HID Mouse) wins.
+func setHIDInterface(device *data.Event, number, driver, class string) {
+	for i, known := range device.Interfaces {
+		if known.Number != number || !strings.HasPrefix(known.Class, "HID") {
+			continue
+		}
+		if len(class) > len(known.Class) {
+			device.Interfaces[i].Class = class
+		}
+		return
+	}
+	addInterface(device, data.Interface{Number: number, Driver: driver, Class: class})
+}
+
+// closeSession records the disconnect of a device and the session duration
+func closeSession(device *data.Event, event data.LogEvent) {
+	addEvidence(device, event)
+	endSession(device, event)
+}
+
+// inferDisconnect ends the session of a device whose port enumerated another
+// device without a disconnect in between, at the connection of the new one
+func inferDisconnect(device *data.Event, event data.LogEvent) {
+	endSession(device, event)
+	device.DisconnectInferred = true
+}
+
+// endSession sets the disconnect time of a device to the time of event and
+// computes the session duration
+func endSession(device *data.Event, event data.LogEvent) {
+	device.DisconnectionTime = event.Date
+	device.SessionOpen = false
+
+	switch {
+	case !device.ConnectedTime.IsZero() && !event.Date.IsZero():
+		device.Duration = event.Date.Sub(device.ConnectedTime)
+	case device.Monotonic > 0 && event.Monotonic > 0:
+		// dmesg output without wall-clock time
+		device.Duration = event.Monotonic - device.Monotonic
+	}
+}
+
+// addEvidence records a log line the device was built from
+func addEvidence(device *data.Event, event data.LogEvent) {
+	device.Evidence = append(device.Evidence, data.Evidence{
+		Source:  event.Source,
+		Line:    event.Line,
+		Offset:  event.Offset,
+		LogLine: event.LogLine,
+	})
+}
@@ -0,0 +1,314 @@
+package parsers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pixfid/luft/core/utils"
+	"github.com/pixfid/luft/data"
+)
+
+// kernLine renders a kernel message logged on ws-01 at clock on 2024-01-01 UTC
+func kernLine(clock, message string) string {
+	return "2024-01-01T" + clock + "Z ws-01 kernel: " + message
+}
+
+// plugLines are the lines logged when a device enumerates on port
+func plugLines(clock, port, number, vid, pid, serial string) []string {
+	lines := []string{
+		kernLine(clock, "usb "+port+": new high-speed USB device number "+number+" using xhci_hcd"),
+		kernLine(clock, "usb "+port+": New USB device found, idVendor="+vid+", idProduct="+pid+", bcdDevice= 1.00"),
+	}
+	if serial != "" {
+		lines = append(lines, kernLine(clock, "usb "+port+": SerialNumber: "+serial))
+	}
+	return lines
+}
+
+func unplugLine(clock, port, number string) string {
+	return kernLine(clock, "usb "+port+": USB disconnect, device number "+number)
+}
+
+// collectLines reads log lines as kern.log and collects the devices
+func collectLines(lines ...[]string) []data.Event {
+	var log []string
+	for _, l := range lines {
+		log = append(log, l...)
+	}
+	return CollectEventsData(parseLine(newLineScanner(strings.NewReader(strings.Join(log, "\n"))), "kern.log"))
+}
+
+// TestCollectPorts checks that attribute lines go to the device enumerated on
+// their port, whatever order the lines of a hub burst were logged in
+func TestCollectPorts(t *testing.T) {
+	events := collectLines([]string{
+		kernLine("10:00:00", "usb 1-1.1: new full-speed USB device number 3 using xhci_hcd"),
+		kernLine("10:00:00", "usb 1-1.2: new high-speed USB device number 4 using xhci_hcd"),
+		kernLine("10:00:00", "usb 1-1.1: New USB device found, idVendor=046d, idProduct=c31c, bcdDevice= 1.10"),
+		kernLine("10:00:00", "usb 1-1.2: New USB device found, idVendor=0781, idProduct=5567, bcdDevice= 1.00"),
+		kernLine("10:00:00", "usb 1-1.2: Product: Cruzer Blade"),
+		kernLine("10:00:00", "usb 1-1.1: Product: USB Keyboard"),
+		kernLine("10:00:00", "usb 1-1.2: SerialNumber: AAA"),
+		kernLine("10:00:00", "usb-storage 1-1.2:1.0: USB Mass Storage device detected"),
+		// A device enumerated before the log starts
+		kernLine("10:00:01", "usb 1-3: SerialNumber: OLD"),
+	})
+
+	type device struct {
+		port, vid, product, serial, speed, number string
+		storage                                   bool
+	}
+	want := []device{
+		{port: "1-1.1", vid: "046d", product: "USB Keyboard", serial: "None", speed: "full-speed", number: "3"},
+		{port: "1-1.2", vid: "0781", product: "Cruzer Blade", serial: "AAA", speed: "high-speed", number: "4", storage: true},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d devices %+v, want %d", len(events), events, len(want))
+	}
+	for i, event := range events {
+		got := device{
+			port: event.ConnectionPort, vid: event.Vid, product: event.ProductName, serial: event.SerialNumber,
+			speed: event.Speed, number: event.DeviceNumber, storage: event.IsMassStorage,
+		}
+		if got != want[i] {
+			t.Errorf("device %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestCollectSessions(t *testing.T) {
+	type session struct {
+		port     string
+		serial   string
+		open     bool
+		inferred bool
+		duration time.Duration
+		shown    string // by FormatSessionDuration
+	}
+
+	tests := []struct {
+		name  string
+		lines [][]string
+		want  []session
+	}{
+		{
+			name: "re-plug on the same port",
+			lines: [][]string{
+				plugLines("10:00:00", "1-2", "5", "0781", "5567", "AAA"),
+				{unplugLine("10:05:00", "1-2", "5")},
+				plugLines("10:10:00", "1-2", "6", "0781", "5567", "AAA"),
+				{unplugLine("10:12:00", "1-2", "6")},
+			},
+			want: []session{
+				{port: "1-2", serial: "AAA", duration: 5 * time.Minute, shown: "5m0s"},
+				{port: "1-2", serial: "AAA", duration: 2 * time.Minute, shown: "2m0s"},
+			},
+		},
+		{
+			// The port enumerates another device: the first one was gone by then
+			name: "missing disconnect",
+			lines: [][]string{
+				plugLines("10:00:00", "1-2", "5", "0781", "5567", "AAA"),
+				plugLines("10:30:00", "1-2", "6", "0951", "1666", "BBB"),
+				{unplugLine("10:31:00", "1-2", "6")},
+			},
+			want: []session{
+				{port: "1-2", serial: "AAA", inferred: true, duration: 30 * time.Minute, shown: "30m0s (inferred)"},
+				{port: "1-2", serial: "BBB", duration: time.Minute, shown: "1m0s"},
+			},
+		},
+		{
+			// A disconnect only closes the session of its own port
+			name: "identical devices on different ports",
+			lines: [][]string{
+				plugLines("10:00:00", "1-2", "5", "0781", "5567", "AAA"),
+				plugLines("10:00:10", "1-3", "6", "0781", "5567", "AAA"),
+				{unplugLine("10:01:10", "1-3", "6")},
+			},
+			want: []session{
+				{port: "1-2", serial: "AAA", open: true, shown: "still connected/unknown"},
+				{port: "1-3", serial: "AAA", duration: time.Minute, shown: "1m0s"},
+			},
+		},
+		{
+			name: "session open at the end of the log",
+			lines: [][]string{
+				plugLines("10:00:00", "1-2", "5", "0781", "5567", "AAA"),
+			},
+			want: []session{
+				{port: "1-2", serial: "AAA", open: true, shown: "still connected/unknown"},
+			},
+		},
+		{
+			name: "disconnect of a device connected before the log starts",
+			lines: [][]string{
+				{kernLine("10:00:00", "usb 1-2: SerialNumber: OLD"), unplugLine("10:01:00", "1-2", "4")},
+				plugLines("10:02:00", "1-2", "5", "0781", "5567", "AAA"),
+			},
+			want: []session{
+				{port: "1-2", serial: "AAA", open: true, shown: "still connected/unknown"},
+			},
+		},
+		{
+			// dmesg output only has the time since boot
+			name: "dmesg",
+			lines: [][]string{{
+				"[  120.000000] usb 1-2: new high-speed USB device number 5 using xhci_hcd",
+				"[  120.150000] usb 1-2: New USB device found, idVendor=0781, idProduct=5567, bcdDevice= 1.00",
+				"[  120.150100] usb 1-2: SerialNumber: AAA",
+				"[  125.500000] usb 1-2: USB disconnect, device number 5",
+			}},
+			want: []session{
+				{port: "1-2", serial: "AAA", duration: 5350 * time.Millisecond, shown: "5s"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := collectLines(tt.lines...)
+
+			if len(events) != len(tt.want) {
+				t.Fatalf("got %d devices %+v, want %d", len(events), events, len(tt.want))
+			}
+			for i, event := range events {
+				got := session{
+					port:     event.ConnectionPort,
+					serial:   event.SerialNumber,
+					open:     event.SessionOpen,
+					inferred: event.DisconnectInferred,
+					duration: event.Duration,
+					shown:    utils.FormatSessionDuration(event),
+				}
+				if got != tt.want[i] {
+					t.Errorf("device %d = %+v, want %+v", i, got, tt.want[i])
+				}
+				// dmesg output has no wall-clock time to disconnect at
+				if !event.ConnectedTime.IsZero() && event.SessionOpen != event.DisconnectionTime.IsZero() {
+					t.Errorf("device %d: open %v, disconnected at %s", i, event.SessionOpen, event.DisconnectionTime)
+				}
+			}
+		})
+	}
+}
+
+// TestCollectEvidence checks that a session keeps the lines it was built from
+func TestCollectEvidence(t *testing.T) {
+	events := collectLines(
+		plugLines("10:00:00", "1-2", "5", "0781", "5567", "AAA"),
+		plugLines("10:30:00", "1-2", "6", "0951", "1666", "BBB"),
+	)
+	if len(events) != 2 {
+		t.Fatalf("got %d devices, want 2", len(events))
+	}
+
+	// The inferred disconnect is not a line of the first device
+	var lines []int
+	for _, evidence := range events[0].Evidence {
+		if evidence.Source != "kern.log" {
+			t.Errorf("evidence from %q", evidence.Source)
+		}
+		lines = append(lines, evidence.Line)
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("evidence lines = %v, want %v", lines, want)
+	}
+	if !events[0].DisconnectionTime.Equal(events[1].ConnectedTime) {
+		t.Errorf("inferred disconnect at %s, want %s", events[0].DisconnectionTime, events[1].ConnectedTime)
+	}
+}
+
+// TestCollectUAS checks that the uas SCSI host line, which names no port, goes
+// to the most recently enumerated device while its session is open
+func TestCollectUAS(t *testing.T) {
+	uas := []string{
+		kernLine("10:00:40", "scsi host6: uas"),
+		kernLine("10:00:41", "sd 6:0:0:0: [sdb] 30253056 512-byte logical blocks: (15.5 GB/14.4 GiB)"),
+		kernLine("10:00:41", " sdb: sdb1"),
+	}
+
+	tests := []struct {
+		name   string
+		lines  [][]string
+		device int // collecting the storage lines, -1 for none
+	}{
+		{
+			name: "latest device",
+			lines: [][]string{
+				plugLines("10:00:00", "1-2", "5", "046d", "c31c", ""),
+				plugLines("10:00:30", "2-1", "2", "0bc2", "ab38", "NA8"),
+				uas,
+			},
+			device: 1,
+		},
+		{
+			name: "latest device disconnected",
+			lines: [][]string{
+				plugLines("10:00:00", "1-2", "5", "046d", "c31c", ""),
+				plugLines("10:00:30", "2-1", "2", "0bc2", "ab38", "NA8"),
+				{unplugLine("10:00:35", "2-1", "2")},
+				uas,
+			},
+			device: -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := collectLines(tt.lines...)
+
+			for i, event := range events {
+				storage := event.IsMassStorage || event.SCSIHost != "" || event.BlockDevice != ""
+				if i != tt.device {
+					if storage {
+						t.Errorf("device %d (%s) got the storage lines", i, event.ConnectionPort)
+					}
+					continue
+				}
+
+				want := []data.Interface{{Driver: "uas", Class: "Mass Storage"}}
+				if !event.IsMassStorage || event.SCSIHost != "6" || event.BlockDevice != "sdb" ||
+					event.Capacity != "15.5 GB" || !reflect.DeepEqual(event.Partitions, []string{"sdb1"}) ||
+					!reflect.DeepEqual(event.Interfaces, want) {
+					t.Errorf("device %d = %+v", i, event)
+				}
+			}
+		})
+	}
+}
+
+// TestCollectHIDDevice checks that hid-generic lines, which name the device by
+// VID:PID only, go to the newest open session of that VID:PID
+func TestCollectHIDDevice(t *testing.T) {
+	hidLine := func(clock, kind, port, input string) string {
+		return kernLine(clock, "hid-generic 0003:046D:C31C.0001: input,hidraw0: USB HID v1.11 "+kind+
+			" [Logitech USB Keyboard] on usb-0000:00:14.0-"+port+"/input"+input)
+	}
+
+	events := collectLines(
+		plugLines("10:00:00", "1-2", "5", "046d", "c31c", ""),
+		plugLines("10:01:00", "1-3", "6", "046d", "c31c", ""),
+		plugLines("10:01:30", "1-4", "7", "0781", "5567", "AAA"),
+		[]string{
+			hidLine("10:01:01", "Keyboard", "3", "0"),
+			unplugLine("10:02:00", "1-3", "6"),
+			hidLine("10:02:30", "Device", "2", "1"),
+		},
+	)
+	if len(events) != 3 {
+		t.Fatalf("got %d devices, want 3", len(events))
+	}
+
+	want := [][]data.Interface{
+		{{Number: "1", Driver: "hid-generic", Class: "HID"}},
+		{{Number: "0", Driver: "hid-generic", Class: "HID Keyboard"}},
+		nil,
+	}
+	for i, event := range events {
+		if !reflect.DeepEqual(event.Interfaces, want[i]) {
+			t.Errorf("interfaces of %s = %+v, want %+v", event.ConnectionPort, event.Interfaces, want[i])
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package parsers
+
+import (
+	"time"
+
+	"github.com/i582/cfmt/cmd/cfmt"
+	"github.com/pixfid/luft/core/database"
+	"github.com/pixfid/luft/core/utils"
+	"github.com/pixfid/luft/data"
+)
+
+// DatabaseEvents reads events persisted by earlier scans and applies the same
+// filters as the local and remote sources
+func DatabaseEvents(params data.ParseParams) error {
+	store, err := database.Open(params.DBPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	scans, err := store.Scans()
+	if err != nil {
+		return err
+	}
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Database contains %d scans}}::green", time.Now().Format(time.Stamp), len(scans)))
+
+	events, err := store.Events(params.OnlyMass)
+	if err != nil {
+		return err
+	}
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Loaded %d stored events}}::green", time.Now().Format(time.Stamp), len(events)))
+
+	// Events stored by older versions have no ID
+	for i := range events {
+		if events[i].ID == "" {
+			events[i].ID = utils.EventID(events[i])
+		}
+	}
+
+	events = utils.FilterEvents(params, events)
+
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Filter complete, %d events found}}::green", time.Now().Format(time.Stamp), len(events)))
+
+	return reportEvents(params, events)
+}
+
+// scanEvents are the events of a scan, to be saved together
+type scanEvents struct {
+	scan   database.Scan
+	events []data.Event
+}
+
+// saveScans persists the unfiltered events of scans from a single store, so the
+// hosts of a parallel scan don't compete for the database lock. Failures are
+// reported but do not abort the scan itself.
+func saveScans(params data.ParseParams, scans ...scanEvents) {
+	store, err := database.Open(params.DBPath)
+	if err != nil {
+		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: failed to save scan to database: %s}}::yellow", time.Now().Format(time.Stamp), err.Error()))
+		return
+	}
+	defer store.Close()
+
+	for _, s := range scans {
+		if s.scan.FinishedAt.IsZero() {
+			s.scan.FinishedAt = time.Now()
+		}
+		scanID, err := store.SaveScan(s.scan, s.events)
+		if err != nil {
+			_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: failed to save scan of %s to database: %s}}::yellow", time.Now().Format(time.Stamp), s.scan.Host, err.Error()))
+			continue
+		}
+
+		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Saved scan #%d with %d events to database}}::green", time.Now().Format(time.Stamp), scanID, len(s.events)))
+	}
+}
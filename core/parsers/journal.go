@@ -0,0 +1,416 @@
+package parsers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/i582/cfmt/cmd/cfmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/pixfid/luft/core/utils"
+	"github.com/pixfid/luft/data"
+	"github.com/ulikunitz/xz"
+)
+
+// systemd journal file format constants
+// See https://systemd.io/JOURNAL_FILE_FORMAT/
+const (
+	journalSignature = "LPKSHHRH"
+
+	journalHeaderMinSize = 208
+	journalObjectHeader  = 16
+	journalEntryItems    = 64 // offset of the item array inside an ENTRY object
+	journalDataPayload   = 64 // offset of the payload inside a DATA object
+	journalDataCompact   = 72 // payload offset when the file uses the compact layout
+
+	journalIncompatXZ      = 1 << 0
+	journalIncompatLZ4     = 1 << 1
+	journalIncompatKeyed   = 1 << 2
+	journalIncompatZSTD    = 1 << 3
+	journalIncompatCompact = 1 << 4
+
+	journalObjectData  = 1
+	journalObjectEntry = 3
+
+	journalCompressedXZ   = 1 << 0
+	journalCompressedLZ4  = 1 << 1
+	journalCompressedZSTD = 1 << 2
+
+	// journalMaxObject guards against corrupted size fields in dirty files
+	journalMaxObject = 64 * 1024 * 1024
+)
+
+// JournalEntry is a single decoded journal entry
+type JournalEntry struct {
+	Seqnum    uint64
+	Offset    uint64 // of the entry object in the journal file
+	Realtime  time.Time
+	Monotonic time.Duration
+	Fields    map[string]string
+}
+
+// JournalReader reads entries from a systemd journal file without journalctl
+type JournalReader struct {
+	file       *os.File
+	path       string
+	compact    bool
+	headerSize uint64
+	tailObject uint64
+	fileSize   uint64
+	zstd       *zstd.Decoder
+}
+
+// IsJournalFile reports whether path looks like an active, archived or dirty journal file
+func IsJournalFile(path string) bool {
+	return strings.HasSuffix(path, ".journal") || strings.HasSuffix(path, ".journal~")
+}
+
+// CollectJournals walks params.LogPath and returns all journal files, including
+// rotated system@... and dirty .journal~ files
+func CollectJournals(params data.ParseParams) ([]string, error) {
+	var files []string
+
+	path, err := utils.ExpandPath(params.LogPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand path %s: %w", params.LogPath, err)
+	}
+
+	err = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: skipping %s: %s}}::yellow", time.Now().Format(time.Stamp), path, err.Error()))
+			return nil
+		}
+		if !info.IsDir() && IsJournalFile(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk directory %s: %w", path, err)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no journal files found in %s", path)
+	}
+
+	return files, nil
+}
+
+// OpenJournal opens a journal file and validates its header
+func OpenJournal(path string) (*JournalReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	jr := &JournalReader{file: file, path: path}
+	if err := jr.readHeader(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return jr, nil
+}
+
+// Close releases the underlying file and decoders
+func (jr *JournalReader) Close() error {
+	if jr.zstd != nil {
+		jr.zstd.Close()
+	}
+	return jr.file.Close()
+}
+
+func (jr *JournalReader) readHeader() error {
+	info, err := jr.file.Stat()
+	if err != nil {
+		return err
+	}
+	jr.fileSize = uint64(info.Size())
+
+	header := make([]byte, journalHeaderMinSize)
+	if _, err := jr.file.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("failed to read journal header: %w", err)
+	}
+
+	if string(header[0:8]) != journalSignature {
+		return fmt.Errorf("not a journal file (bad signature)")
+	}
+
+	incompatible := binary.LittleEndian.Uint32(header[12:16])
+	known := uint32(journalIncompatXZ | journalIncompatLZ4 | journalIncompatKeyed | journalIncompatZSTD | journalIncompatCompact)
+	if incompatible&^known != 0 {
+		return fmt.Errorf("unsupported journal incompatible flags 0x%x", incompatible)
+	}
+	jr.compact = incompatible&journalIncompatCompact != 0
+
+	jr.headerSize = binary.LittleEndian.Uint64(header[88:96])
+	jr.tailObject = binary.LittleEndian.Uint64(header[136:144])
+
+	if jr.headerSize < journalHeaderMinSize || jr.headerSize > jr.fileSize {
+		return fmt.Errorf("invalid journal header size %d", jr.headerSize)
+	}
+
+	// Dirty (.journal~) or truncated copies may point past the end of the file
+	if jr.tailObject == 0 || jr.tailObject >= jr.fileSize {
+		jr.tailObject = jr.fileSize
+	}
+
+	return nil
+}
+
+// readObject returns the raw bytes of the object at offset including its header
+func (jr *JournalReader) readObject(offset uint64) (objType uint8, flags uint8, obj []byte, err error) {
+	var hdr [journalObjectHeader]byte
+	if offset+journalObjectHeader > jr.fileSize {
+		return 0, 0, nil, io.ErrUnexpectedEOF
+	}
+	if _, err := jr.file.ReadAt(hdr[:], int64(offset)); err != nil {
+		return 0, 0, nil, err
+	}
+
+	size := binary.LittleEndian.Uint64(hdr[8:16])
+	if size < journalObjectHeader || size > journalMaxObject || offset+size > jr.fileSize {
+		return 0, 0, nil, fmt.Errorf("invalid object size %d at offset %d", size, offset)
+	}
+
+	obj = make([]byte, size)
+	if _, err := jr.file.ReadAt(obj, int64(offset)); err != nil {
+		return 0, 0, nil, err
+	}
+
+	return hdr[0], hdr[1], obj, nil
+}
+
+// readData returns the decompressed payload ("FIELD=value") of a DATA object
+func (jr *JournalReader) readData(offset uint64) ([]byte, error) {
+	objType, flags, obj, err := jr.readObject(offset)
+	if err != nil {
+		return nil, err
+	}
+	if objType != journalObjectData {
+		return nil, fmt.Errorf("expected data object at offset %d, got type %d", offset, objType)
+	}
+
+	start := uint64(journalDataPayload)
+	if jr.compact {
+		start = journalDataCompact
+	}
+	if uint64(len(obj)) < start {
+		return nil, fmt.Errorf("truncated data object at offset %d", offset)
+	}
+
+	return jr.decompress(flags, obj[start:])
+}
+
+func (jr *JournalReader) decompress(flags uint8, payload []byte) ([]byte, error) {
+	switch {
+	case flags&journalCompressedZSTD != 0:
+		if jr.zstd == nil {
+			dec, err := zstd.NewReader(nil)
+			if err != nil {
+				return nil, err
+			}
+			jr.zstd = dec
+		}
+		return jr.zstd.DecodeAll(payload, nil)
+
+	case flags&journalCompressedXZ != 0:
+		r, err := xz.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+
+	case flags&journalCompressedLZ4 != 0:
+		// systemd prefixes the raw LZ4 block with the 64-bit uncompressed size
+		if len(payload) < 8 {
+			return nil, fmt.Errorf("truncated lz4 payload")
+		}
+		size := binary.LittleEndian.Uint64(payload[:8])
+		if size > journalMaxObject {
+			return nil, fmt.Errorf("invalid lz4 payload size %d", size)
+		}
+		out := make([]byte, size)
+		n, err := lz4.UncompressBlock(payload[8:], out)
+		if err != nil {
+			return nil, err
+		}
+		return out[:n], nil
+	}
+
+	return payload, nil
+}
+
+// parseEntry decodes an ENTRY object into its header values and fields
+func (jr *JournalReader) parseEntry(obj []byte) (JournalEntry, error) {
+	if len(obj) < journalEntryItems {
+		return JournalEntry{}, fmt.Errorf("truncated entry object")
+	}
+
+	entry := JournalEntry{
+		Seqnum:    binary.LittleEndian.Uint64(obj[16:24]),
+		Realtime:  time.UnixMicro(int64(binary.LittleEndian.Uint64(obj[24:32]))),
+		Monotonic: time.Duration(binary.LittleEndian.Uint64(obj[32:40])) * time.Microsecond,
+		Fields:    make(map[string]string),
+	}
+
+	itemSize := 16
+	if jr.compact {
+		itemSize = 4
+	}
+
+	for pos := journalEntryItems; pos+itemSize <= len(obj); pos += itemSize {
+		var offset uint64
+		if jr.compact {
+			offset = uint64(binary.LittleEndian.Uint32(obj[pos : pos+4]))
+		} else {
+			offset = binary.LittleEndian.Uint64(obj[pos : pos+8])
+		}
+		if offset == 0 {
+			continue
+		}
+
+		payload, err := jr.readData(offset)
+		if err != nil {
+			return entry, err
+		}
+
+		if idx := bytes.IndexByte(payload, '='); idx > 0 {
+			entry.Fields[string(payload[:idx])] = string(payload[idx+1:])
+		}
+	}
+
+	return entry, nil
+}
+
+// ForEach calls fn for every entry in the file in write order. Objects are walked
+// sequentially so that dirty or partially copied files are read up to the first
+// damaged object instead of being rejected entirely.
+func (jr *JournalReader) ForEach(fn func(JournalEntry) error) error {
+	offset := jr.headerSize
+
+	for offset <= jr.tailObject {
+		objType, _, obj, err := jr.readObject(offset)
+		if err != nil {
+			if offset >= jr.tailObject {
+				return nil
+			}
+			return fmt.Errorf("stopped reading %s at offset %d: %w", jr.path, offset, err)
+		}
+
+		if objType == journalObjectEntry {
+			entry, err := jr.parseEntry(obj)
+			if err != nil {
+				return fmt.Errorf("failed to read entry at offset %d in %s: %w", offset, jr.path, err)
+			}
+			entry.Offset = offset
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+
+		// Objects are 8-byte aligned
+		offset += (uint64(len(obj)) + 7) &^ 7
+	}
+
+	return nil
+}
+
+// journalLogEvent converts a journal entry into a LogEvent. The log line is
+// rendered in the classic syslog/kern.log layout so CollectEventsData can handle it
+// the same way as lines read from text logs, while Date keeps the exact
+// microsecond __REALTIME_TIMESTAMP. Besides kernel messages, udisks mount messages
+// and logind/PAM session messages are kept to link devices to mount points and users.
+func journalLogEvent(entry JournalEntry) (data.LogEvent, bool) {
+	hostName := entry.Fields["_HOSTNAME"]
+	if hostName == "" {
+		hostName = "unknown"
+	}
+
+	var logLine string
+	var monotonic time.Duration
+	kernel := entry.Fields["_TRANSPORT"] == "kernel"
+	if kernel {
+		monotonic = entry.Monotonic
+		if source, err := strconv.ParseUint(entry.Fields["_SOURCE_MONOTONIC_TIMESTAMP"], 10, 64); err == nil {
+			monotonic = time.Duration(source) * time.Microsecond
+		}
+
+		logLine = fmt.Sprintf("%s %s kernel: [%12.6f] %s",
+			entry.Realtime.Format(time.Stamp), hostName, monotonic.Seconds(), entry.Fields["MESSAGE"])
+	} else {
+		identifier := entry.Fields["SYSLOG_IDENTIFIER"]
+		if identifier == "" {
+			identifier = entry.Fields["_COMM"]
+		}
+		if pid := entry.Fields["_PID"]; pid != "" {
+			identifier = fmt.Sprintf("%s[%s]", identifier, pid)
+		}
+
+		logLine = fmt.Sprintf("%s %s %s: %s",
+			entry.Realtime.Format(time.Stamp), hostName, identifier, entry.Fields["MESSAGE"])
+	}
+
+	eventType := classifyLine(logLine)
+	if eventType == data.Unknown {
+		return data.LogEvent{}, false
+	}
+
+	// Only mount and session messages are taken from services, USB events come from the kernel
+	if !kernel {
+		switch {
+		case eventType == data.Login, eventType == data.Logout, eventType == data.Boot:
+		case reUdisksMount.MatchString(entry.Fields["MESSAGE"]):
+		default:
+			return data.LogEvent{}, false
+		}
+	}
+
+	return data.LogEvent{
+		Date:       entry.Realtime,
+		ActionType: eventType,
+		LogLine:    logLine,
+		Line:       int(entry.Seqnum),
+		Offset:     int64(entry.Offset),
+		Host:       hostName,
+		Monotonic:  monotonic,
+	}, true
+}
+
+// readJournal streams USB related events from a journal file to fn
+func readJournal(path string, fn func(data.LogEvent) error) error {
+	jr, err := OpenJournal(path)
+	if err != nil {
+		return err
+	}
+	defer jr.Close()
+
+	return jr.ForEach(func(entry JournalEntry) error {
+		if event, ok := journalLogEvent(entry); ok {
+			event.Source = path
+			return fn(event)
+		}
+		return nil
+	})
+}
+
+func parseJournal(path string) []data.LogEvent {
+	var logEvents []data.LogEvent
+
+	err := readJournal(path, func(event data.LogEvent) error {
+		logEvents = append(logEvents, event)
+		return nil
+	})
+	if err != nil {
+		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: %s: %s}}::yellow", time.Now().Format(time.Stamp), path, err.Error()))
+	}
+
+	return logEvents
+}
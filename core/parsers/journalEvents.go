@@ -0,0 +1,44 @@
+package parsers
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/i582/cfmt/cmd/cfmt"
+	"github.com/pixfid/luft/core/database"
+	"github.com/pixfid/luft/core/utils"
+	"github.com/pixfid/luft/data"
+)
+
+// JournalEvents reads USB events from systemd journal files found under
+// params.LogPath. It works on offline copies of a journal directory and does not
+// depend on journalctl.
+func JournalEvents(params data.ParseParams) error {
+	startedAt := time.Now()
+
+	path, err := utils.ExpandPath(params.LogPath)
+	if err != nil {
+		return fmt.Errorf("failed to expand log path: %w", err)
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return fmt.Errorf("journal directory does not exist: %s", path)
+	}
+
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Reading journal from: }}::green {{%s}}::red", time.Now().Format(time.Stamp), path))
+
+	list, err := CollectJournals(params)
+	if err != nil {
+		return fmt.Errorf("failed to collect journal files: %w", err)
+	}
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Loaded %d journal files}}::green", time.Now().Format(time.Stamp), len(list)))
+
+	// Journals may hold entries from several machines, each event keeps its own _HOSTNAME
+	return processLogFiles(params, list, nil, database.Scan{
+		Source:    "journal",
+		Host:      path,
+		LogPath:   path,
+		StartedAt: startedAt,
+	})
+}
@@ -0,0 +1,304 @@
+package parsers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/pixfid/luft/data"
+	"github.com/ulikunitz/xz"
+)
+
+// journalBuilder writes a minimal journal file in the regular (non compact)
+// layout: the header followed by 8-byte aligned DATA and ENTRY objects. Hash
+// tables and entry arrays are left out, the reader walks objects in file order.
+type journalBuilder struct {
+	buf        []byte
+	incompat   uint32
+	tailObject uint64
+}
+
+func newJournalBuilder() *journalBuilder {
+	return &journalBuilder{buf: make([]byte, journalHeaderMinSize)}
+}
+
+func (b *journalBuilder) object(objType, flags uint8, body []byte) uint64 {
+	offset := uint64(len(b.buf))
+	hdr := make([]byte, journalObjectHeader)
+	hdr[0], hdr[1] = objType, flags
+	binary.LittleEndian.PutUint64(hdr[8:16], uint64(journalObjectHeader+len(body)))
+
+	b.buf = append(b.buf, hdr...)
+	b.buf = append(b.buf, body...)
+	for len(b.buf)%8 != 0 {
+		b.buf = append(b.buf, 0)
+	}
+	b.tailObject = offset
+	return offset
+}
+
+// data adds a DATA object holding payload, compressed with the journal
+// compression flags given
+func (b *journalBuilder) data(t *testing.T, payload string, flags uint8) uint64 {
+	t.Helper()
+
+	stored := []byte(payload)
+	switch flags {
+	case journalCompressedXZ:
+		var out bytes.Buffer
+		w, err := xz.NewWriter(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(stored); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		stored = out.Bytes()
+		b.incompat |= journalIncompatXZ
+
+	case journalCompressedLZ4:
+		block := make([]byte, lz4.CompressBlockBound(len(stored)))
+		n, err := lz4.CompressBlock(stored, block, nil)
+		if err != nil || n == 0 {
+			t.Fatalf("lz4 compress: %d, %v", n, err)
+		}
+		size := make([]byte, 8)
+		binary.LittleEndian.PutUint64(size, uint64(len(stored)))
+		stored = append(size, block[:n]...)
+		b.incompat |= journalIncompatLZ4
+
+	case journalCompressedZSTD:
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored = enc.EncodeAll(stored, nil)
+		_ = enc.Close()
+		b.incompat |= journalIncompatZSTD
+	}
+
+	// hash, next hash, next field, entry, entry array and entry count
+	body := make([]byte, journalDataPayload-journalObjectHeader)
+	return b.object(journalObjectData, flags, append(body, stored...))
+}
+
+func (b *journalBuilder) entry(seqnum uint64, realtime time.Time, monotonic time.Duration, items ...uint64) uint64 {
+	body := make([]byte, journalEntryItems-journalObjectHeader)
+	binary.LittleEndian.PutUint64(body[0:8], seqnum)
+	binary.LittleEndian.PutUint64(body[8:16], uint64(realtime.UnixMicro()))
+	binary.LittleEndian.PutUint64(body[16:24], uint64(monotonic.Microseconds()))
+
+	for _, item := range items {
+		// object offset and hash
+		pair := make([]byte, 16)
+		binary.LittleEndian.PutUint64(pair[0:8], item)
+		body = append(body, pair...)
+	}
+	return b.object(journalObjectEntry, 0, body)
+}
+
+func (b *journalBuilder) bytes() []byte {
+	out := append([]byte(nil), b.buf...)
+	copy(out[0:8], journalSignature)
+	binary.LittleEndian.PutUint32(out[12:16], b.incompat)
+	binary.LittleEndian.PutUint64(out[88:96], journalHeaderMinSize)
+	binary.LittleEndian.PutUint64(out[136:144], b.tailObject)
+	return out
+}
+
+func writeJournal(t *testing.T, content []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "system.journal")
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+var journalBoot = time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+
+// usbJournal writes five entries: a USB connect with its MESSAGE stored plain,
+// the descriptor line and the disconnect with xz, lz4 and zstd compressed
+// MESSAGE objects, an unrelated kernel message and a service message that is
+// not a mount or session message
+func usbJournal(t *testing.T) (content []byte, entries []uint64) {
+	t.Helper()
+	b := newJournalBuilder()
+
+	kernel := b.data(t, "_TRANSPORT=kernel", 0)
+	host := b.data(t, "_HOSTNAME=ws-01", journalCompressedXZ)
+
+	add := func(seqnum uint64, after time.Duration, message string, flags uint8, fields ...uint64) {
+		items := append([]uint64{b.data(t, "MESSAGE="+message, flags)}, fields...)
+		entries = append(entries, b.entry(seqnum, journalBoot.Add(after), 120*time.Second+after, items...))
+	}
+
+	add(1, 0, "usb 1-2: new high-speed USB device number 5 using xhci_hcd", 0, kernel, host)
+	add(2, 150*time.Millisecond, "usb 1-2: New USB device found, idVendor=0781, idProduct=5567, bcdDevice= 1.00", journalCompressedXZ, kernel, host)
+	add(3, time.Second, "e1000e: eth0 NIC Link is Up", 0, kernel, host)
+	add(4, 2*time.Second, "Started Daily apt upgrade and clean activities.", journalCompressedLZ4, b.data(t, "SYSLOG_IDENTIFIER=systemd", 0), host)
+	add(5, time.Minute, "usb 1-2: USB disconnect, device number 5", journalCompressedZSTD, kernel, host)
+
+	return b.bytes(), entries
+}
+
+func TestJournalForEach(t *testing.T) {
+	content, offsets := usbJournal(t)
+
+	jr, err := OpenJournal(writeJournal(t, content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jr.Close()
+
+	var entries []JournalEntry
+	if err := jr.ForEach(func(entry JournalEntry) error {
+		entries = append(entries, entry)
+		return nil
+	}); err != nil {
+		t.Fatalf("ForEach: %v", err)
+	}
+
+	if len(entries) != 5 {
+		t.Fatalf("got %d entries, want 5", len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Seqnum != uint64(i+1) || entry.Offset != offsets[i] {
+			t.Errorf("entry %d: seqnum %d at %d, want %d at %d", i, entry.Seqnum, entry.Offset, i+1, offsets[i])
+		}
+	}
+
+	want := map[string]string{
+		"MESSAGE":    "usb 1-2: New USB device found, idVendor=0781, idProduct=5567, bcdDevice= 1.00",
+		"_TRANSPORT": "kernel",
+		"_HOSTNAME":  "ws-01",
+	}
+	if got := entries[1]; !reflect.DeepEqual(got.Fields, want) {
+		t.Errorf("fields = %q, want %q", got.Fields, want)
+	}
+	if got := entries[1].Realtime; !got.Equal(journalBoot.Add(150 * time.Millisecond)) {
+		t.Errorf("realtime = %s", got)
+	}
+	if got := entries[1].Monotonic; got != 120150*time.Millisecond {
+		t.Errorf("monotonic = %s", got)
+	}
+	if got := entries[3].Fields["MESSAGE"]; got != "Started Daily apt upgrade and clean activities." {
+		t.Errorf("lz4 MESSAGE = %q", got)
+	}
+	if got := entries[4].Fields["MESSAGE"]; got != "usb 1-2: USB disconnect, device number 5" {
+		t.Errorf("zstd MESSAGE = %q", got)
+	}
+}
+
+// TestReadJournal checks that only the USB kernel messages of the journal are
+// turned into events, rendered as kern.log lines
+func TestReadJournal(t *testing.T) {
+	content, offsets := usbJournal(t)
+	path := writeJournal(t, content)
+
+	events := parseJournal(path)
+
+	want := []struct {
+		action data.ActionType
+		line   string
+		seqnum int
+	}{
+		{data.Connected, "Jan  1 10:00:00 ws-01 kernel: [  120.000000] usb 1-2: new high-speed USB device number 5 using xhci_hcd", 1},
+		{data.Connected, "Jan  1 10:00:00 ws-01 kernel: [  120.150000] usb 1-2: New USB device found, idVendor=0781, idProduct=5567, bcdDevice= 1.00", 2},
+		{data.Disconnected, "Jan  1 10:01:00 ws-01 kernel: [  180.000000] usb 1-2: USB disconnect, device number 5", 5},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events %+v, want %d", len(events), events, len(want))
+	}
+	for i, event := range events {
+		if event.ActionType != want[i].action || event.LogLine != want[i].line || event.Line != want[i].seqnum {
+			t.Errorf("event %d = %v %q line %d, want %v %q line %d",
+				i, event.ActionType, event.LogLine, event.Line, want[i].action, want[i].line, want[i].seqnum)
+		}
+		if event.Host != "ws-01" || event.Source != path || event.Offset != int64(offsets[want[i].seqnum-1]) {
+			t.Errorf("event %d from %s in %s at %d", i, event.Host, event.Source, event.Offset)
+		}
+	}
+}
+
+// TestJournalTruncated reads copies cut off in the middle of an object, as left
+// behind by a crash or a partial copy of an active journal. The entries before
+// the damage are read either way, a damaged tail object is not an error.
+func TestJournalTruncated(t *testing.T) {
+	content, offsets := usbJournal(t)
+
+	tests := []struct {
+		name    string
+		size    uint64
+		seqnums []uint64
+		events  int
+		err     bool
+	}{
+		{name: "in the tail object", size: offsets[4] + 24, seqnums: []uint64{1, 2, 3, 4}, events: 2},
+		{name: "before the tail object", size: offsets[2] + 24, seqnums: []uint64{1, 2}, events: 2, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeJournal(t, content[:tt.size])
+
+			jr, err := OpenJournal(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer jr.Close()
+
+			var seqnums []uint64
+			err = jr.ForEach(func(entry JournalEntry) error {
+				seqnums = append(seqnums, entry.Seqnum)
+				return nil
+			})
+			if tt.err != (err != nil) || err != nil && !strings.Contains(err.Error(), "stopped reading") {
+				t.Errorf("error = %v, want error %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(seqnums, tt.seqnums) {
+				t.Errorf("seqnums = %v, want %v", seqnums, tt.seqnums)
+			}
+
+			if events := parseJournal(path); len(events) != tt.events {
+				t.Errorf("got %d events, want %d", len(events), tt.events)
+			}
+		})
+	}
+}
+
+func TestOpenJournalErrors(t *testing.T) {
+	content, _ := usbJournal(t)
+
+	unknown := append([]byte(nil), content...)
+	binary.LittleEndian.PutUint32(unknown[12:16], 1<<10)
+
+	tests := []struct {
+		name    string
+		content []byte
+		err     string
+	}{
+		{"bad signature", append([]byte("XXXXXXXX"), content[8:]...), "bad signature"},
+		{"unknown incompatible flag", unknown, "unsupported journal incompatible flags"},
+		{"header cut short", content[:100], "failed to read journal header"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := OpenJournal(writeJournal(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error = %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -2,15 +2,20 @@ package parsers
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"time"
 
 	"github.com/i582/cfmt/cmd/cfmt"
+	"github.com/pixfid/luft/core/analysis"
+	"github.com/pixfid/luft/core/database"
 	"github.com/pixfid/luft/core/utils"
 	"github.com/pixfid/luft/data"
 )
 
 func LocalEvents(params data.ParseParams) error {
+	startedAt := time.Now()
+
 	path, err := utils.ExpandPath(params.LogPath)
 	if err != nil {
 		return fmt.Errorf("failed to expand log path: %w", err)
@@ -22,18 +27,73 @@ func LocalEvents(params data.ParseParams) error {
 		hostName = "unknown"
 	}
 
-	if _, err := os.Stat(path); os.IsNotExist(err) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
 		return fmt.Errorf("log directory does not exist: %s", path)
 	}
 
 	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Starting on: }}::green {{%s}}::red", time.Now().Format(time.Stamp), hostName))
 
-	list, err := CollectLogs(params)
+	scan := database.Scan{
+		Source:    "local",
+		Host:      hostName,
+		LogPath:   path,
+		StartedAt: startedAt,
+	}
+
+	if err == nil && !info.IsDir() {
+		return localArchiveEvents(params, path, scan)
+	}
+
+	files, err := CollectLogs(params)
 	if err != nil {
 		return fmt.Errorf("failed to collect log files: %w", err)
 	}
-	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Loaded %d logs files}}::green", time.Now().Format(time.Stamp), len(list)))
+	if params.DryRun {
+		PrintLogFileDecisions(path, files)
+		return nil
+	}
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Loaded %d logs files}}::green", time.Now().Format(time.Stamp), len(files.Logs)))
+
+	sessions := readLoginFiles(files.LoginFiles, func(path string) (io.ReadCloser, error) {
+		return os.Open(path)
+	})
+
+	return processLogFiles(params, files.Logs, sessions, scan)
+}
+
+// localArchiveEvents reads the logs of a tar or zip evidence archive, such as
+// var-log.tar.gz or a triage bundle, without extracting it
+func localArchiveEvents(params data.ParseParams, path string, scan database.Scan) error {
+	if !IsArchive(path) {
+		return fmt.Errorf("%s is not a directory or a tar/zip archive", path)
+	}
+
+	selector, err := NewLogSelector(params.LogInclude, params.LogExclude)
+	if err != nil {
+		return err
+	}
+
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Reading archive %s}}::green", time.Now().Format(time.Stamp), path))
+
+	logs, err := readArchive(params.Ctx, path, selector, params.DryRun)
+	if err != nil {
+		return err
+	}
+	if params.DryRun {
+		PrintLogFileDecisions(path, logs.LogFiles)
+		return nil
+	}
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Loaded %d logs files}}::green", time.Now().Format(time.Stamp), len(logs.Logs)))
 
+	scan.Files = len(logs.Logs)
+	return processLogEvents(params, logs.records, logs.ModTimes, logs.sessions, scan)
+}
+
+// processLogFiles parses the collected files and prints or exports the resulting events.
+// sessions read from wtmp/btmp are added to the sessions found in the logs, scan
+// carries the metadata recorded when params.SaveToDB is set.
+func processLogFiles(params data.ParseParams, list []string, sessions []data.Session, scan database.Scan) error {
 	// Print initial memory stats
 	if params.Streaming {
 		PrintMemoryStats("before parsing")
@@ -56,17 +116,54 @@ func LocalEvents(params data.ParseParams) error {
 	default:
 	}
 
+	scan.Files = len(list)
+	return processLogEvents(params, recordTypes, utils.LogModTimes(list), sessions, scan)
+}
+
+// processLogEvents turns the parsed records into events and prints or exports
+// them. modTimes are the modification times of the files the records come from.
+func processLogEvents(params data.ParseParams, recordTypes []data.LogEvent, modTimes map[string]time.Time, sessions []data.Session, scan database.Scan) error {
 	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Found %d events records}}::green", time.Now().Format(time.Stamp), len(recordTypes)))
 
+	utils.ResolveYears(recordTypes, modTimes, params.ReferenceDate)
+
 	events := CollectEventsData(recordTypes)
 	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Parsed %d events}}::green", time.Now().Format(time.Stamp), len(events)))
 
+	annotateUsers(events, append(CollectSessions(recordTypes), sessions...))
+
 	events = utils.RemoveDuplicates(events)
+
+	if params.SaveToDB {
+		saveScans(params, scanEvents{scan: scan, events: events})
+	}
+
 	events = utils.FilterEvents(params, events)
 
 	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Filter and remove duplicates complete, %d clear events found}}::green", time.Now().Format(time.Stamp), len(events)))
 
+	return reportEvents(params, events)
+}
+
+// reportEvents exports events or prints them as a table. With params.Explain set
+// the evidence lines of one event are printed instead, with params.Analysis the
+// events are analysed and the findings reported, with params.Generate a whitelist
+// of the devices is written.
+func reportEvents(params data.ParseParams, events []data.Event) error {
+	if params.Explain != "" {
+		return utils.PrintEvidence(events, params.Explain)
+	}
+	if params.Analysis != "" {
+		return analysis.Run(params, events)
+	}
+	if params.Generate != "" {
+		return utils.GenerateWhiteList(events, params.Generate, params.FileName)
+	}
+
 	if params.Export {
+		if !params.Evidence {
+			events = utils.WithoutEvidence(events)
+		}
 		if err := utils.ExportData(events, params.Format, params.FileName); err != nil {
 			return fmt.Errorf("failed to export events: %w", err)
 		}
@@ -77,3 +174,9 @@ func LocalEvents(params data.ParseParams) error {
 
 	return nil
 }
+
+// annotateUsers links events to the user sessions found for the scan
+func annotateUsers(events []data.Event, sessions []data.Session) {
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Found %d user sessions and failed logins}}::green", time.Now().Format(time.Stamp), len(sessions)))
+	AnnotateUsers(events, sessions)
+}
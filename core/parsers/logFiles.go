@@ -0,0 +1,360 @@
+package parsers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/i582/cfmt/cmd/cfmt"
+	"github.com/kr/fs"
+	"github.com/olekukonko/tablewriter"
+	"github.com/olekukonko/tablewriter/renderer"
+	"github.com/pixfid/luft/core/utils"
+)
+
+// DefaultLogPatterns are the log files parsed when no include pattern is given.
+// Their rotated and compressed copies (kern.log.1, syslog.2.gz, messages-20240101) match too.
+var DefaultLogPatterns = []string{
+	"syslog",
+	"messages",
+	"kern.log",
+	"kernel.log",
+	"daemon.log",
+	"dmesg",
+	"boot.log",
+	// Login sessions (systemd-logind, PAM)
+	"auth.log",
+	"secure",
+}
+
+var (
+	// Rotation suffixes of logrotate: .1, .2, -20240101
+	reRotation = regexp.MustCompile(`^(.+?)(?:[.-]\d+)+$`)
+
+	// compressionExtensions are the extensions of compressed rotated logs
+	compressionExtensions = []string{".gz", ".xz", ".bz2", ".zst", ".lz4"}
+)
+
+// dryRunMu keeps apart the listings of hosts scanned at once
+var dryRunMu sync.Mutex
+
+// sniffLength is the number of bytes read to tell text logs from binary files
+const sniffLength = 512
+
+// logPattern is an include or exclude pattern. A glob without a '/' matches
+// names, a glob with a '/' matches the path relative to the log directory, and
+// a regexp (re:...) matches the relative path.
+type logPattern struct {
+	text     string
+	glob     string
+	re       *regexp.Regexp
+	relative bool
+}
+
+// parseLogPattern compiles a glob or re: prefixed regexp
+func parseLogPattern(text string) (logPattern, error) {
+	if expr, ok := strings.CutPrefix(text, "re:"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return logPattern{}, fmt.Errorf("invalid log file pattern %q: %w", text, err)
+		}
+		return logPattern{text: text, re: re, relative: true}, nil
+	}
+
+	if _, err := path.Match(text, ""); err != nil {
+		return logPattern{}, fmt.Errorf("invalid log file pattern %q: %w", text, err)
+	}
+	return logPattern{text: text, glob: text, relative: strings.Contains(text, "/")}, nil
+}
+
+// matches reports whether the pattern matches the file at rel, relative to the
+// log directory, or the file it is a rotated copy of
+func (p logPattern) matches(rel string) bool {
+	for _, candidate := range []string{rel, path.Join(path.Dir(rel), logBaseName(path.Base(rel)))} {
+		subject := candidate
+		if !p.relative {
+			subject = path.Base(candidate)
+		}
+
+		if p.re != nil {
+			if p.re.MatchString(subject) {
+				return true
+			}
+		} else if ok, _ := path.Match(p.glob, subject); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// logBaseName returns the name of the log a rotated or compressed copy comes
+// from: kern.log.1.gz is a copy of kern.log
+func logBaseName(name string) string {
+	for _, ext := range compressionExtensions {
+		name = strings.TrimSuffix(name, ext)
+	}
+	if m := reRotation.FindStringSubmatch(name); m != nil {
+		return m[1]
+	}
+	return name
+}
+
+// LogSelector picks the files of a log directory tree to parse
+type LogSelector struct {
+	include []logPattern
+	exclude []logPattern
+}
+
+// NewLogSelector returns a selector of the files matching one of include, or of
+// the default log files when include is empty, and none of exclude. Patterns
+// are globs, or regexps when prefixed with re:.
+func NewLogSelector(include []string, exclude []string) (LogSelector, error) {
+	if len(include) == 0 {
+		include = DefaultLogPatterns
+	}
+
+	var selector LogSelector
+	for _, text := range include {
+		pattern, err := parseLogPattern(text)
+		if err != nil {
+			return LogSelector{}, err
+		}
+		selector.include = append(selector.include, pattern)
+	}
+	for _, text := range exclude {
+		pattern, err := parseLogPattern(text)
+		if err != nil {
+			return LogSelector{}, err
+		}
+		selector.exclude = append(selector.exclude, pattern)
+	}
+
+	return selector, nil
+}
+
+// firstMatch returns the first of patterns matching rel
+func firstMatch(patterns []logPattern, rel string) (logPattern, bool) {
+	for _, pattern := range patterns {
+		if pattern.matches(rel) {
+			return pattern, true
+		}
+	}
+	return logPattern{}, false
+}
+
+// logFileKind is what a selector makes of a file
+type logFileKind int
+
+const (
+	unmatchedFile logFileKind = iota // matches no include pattern
+	excludedFile
+	loginFile // wtmp or btmp
+	logFile
+)
+
+// classify tells what the file at rel, relative to the log directory, is and
+// why. Files in an excluded directory are excluded, for archive members whose
+// directories are not walked.
+func (s LogSelector) classify(rel string) (logFileKind, string) {
+	if pattern, ok := firstMatch(s.exclude, rel); ok {
+		return excludedFile, "excluded by " + pattern.text
+	}
+	for dir := path.Dir(rel); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if pattern, ok := firstMatch(s.exclude, dir); ok {
+			return excludedFile, "directory excluded by " + pattern.text
+		}
+	}
+	if IsLoginRecordFile(rel) {
+		return loginFile, "login records (wtmp/btmp)"
+	}
+	if pattern, ok := firstMatch(s.include, rel); ok {
+		return logFile, "matches " + pattern.text
+	}
+	return unmatchedFile, ""
+}
+
+// LogFileDecision records whether a file found in a log directory is parsed, and why
+type LogFileDecision struct {
+	Path   string
+	Parsed bool
+	Reason string
+}
+
+// LogFiles are the files found in a log directory tree
+type LogFiles struct {
+	Logs       []string             // text logs to parse
+	LoginFiles []string             // wtmp and btmp files
+	ModTimes   map[string]time.Time // used to infer the year of syslog timestamps
+	Decisions  []LogFileDecision    // files matched by a pattern, parsed or not
+	Unmatched  int                  // files matching no include pattern
+}
+
+// Walk selects the files under root, walking it with walker and reading the
+// head of the selected files with open. Local directories are walked with
+// fs.Walk and remote ones with sftp.Client.Walk, so both are selected alike.
+// Unreadable subdirectories are skipped with a warning.
+func (s LogSelector) Walk(root string, walker *fs.Walker, open func(path string) (io.ReadCloser, error)) (LogFiles, error) {
+	files := LogFiles{ModTimes: make(map[string]time.Time)}
+	decide := func(path string, parsed bool, reason string, args ...any) {
+		files.Decisions = append(files.Decisions, LogFileDecision{Path: path, Parsed: parsed, Reason: fmt.Sprintf(reason, args...)})
+	}
+
+	for walker.Step() {
+		current := walker.Path()
+		if err := walker.Err(); err != nil {
+			if current == root {
+				return files, err
+			}
+			_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: skipping %s: %s}}::yellow", time.Now().Format(time.Stamp), current, err.Error()))
+			continue
+		}
+		if current == root {
+			continue
+		}
+
+		rel, err := filepath.Rel(root, current)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+
+		info := walker.Stat()
+		if info.IsDir() {
+			if pattern, ok := firstMatch(s.exclude, rel); ok {
+				decide(current+"/", false, "directory excluded by %s", pattern.text)
+				walker.SkipDir()
+			}
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		kind, reason := s.classify(rel)
+		switch {
+		case kind == unmatchedFile:
+			files.Unmatched++
+			continue
+		case kind == excludedFile:
+			decide(current, false, "%s", reason)
+			continue
+		case kind == loginFile:
+			files.LoginFiles = append(files.LoginFiles, current)
+			decide(current, true, "%s", reason)
+			continue
+		case info.Size() == 0:
+			decide(current, false, "empty file")
+			continue
+		}
+		if skipped := sniffLog(current, open); skipped != "" {
+			decide(current, false, "%s", skipped)
+			continue
+		}
+
+		files.Logs = append(files.Logs, current)
+		files.ModTimes[current] = info.ModTime()
+		decide(current, true, "%s", reason)
+	}
+
+	return files, nil
+}
+
+// WalkLocal selects the files under the local directory root
+func (s LogSelector) WalkLocal(root string) (LogFiles, error) {
+	return s.Walk(root, fs.Walk(root), func(path string) (io.ReadCloser, error) {
+		return os.Open(path)
+	})
+}
+
+// sniffLog reads the head of a log file, decompressed when it is compressed,
+// and returns why it can't be parsed, empty when it looks like text
+func sniffLog(path string, open func(path string) (io.ReadCloser, error)) string {
+	reader, err := utils.OpenDecompressed(path, open)
+	if err != nil {
+		return fmt.Sprintf("can't be read: %s", err.Error())
+	}
+	defer reader.Close()
+
+	return sniffHead(bufio.NewReader(reader))
+}
+
+// sniffHead peeks at the head of decompressed log content and returns why it
+// can't be parsed, empty when it looks like text
+func sniffHead(reader *bufio.Reader) string {
+	head, err := reader.Peek(sniffLength)
+	if err != nil && err != io.EOF {
+		return fmt.Sprintf("can't be read: %s", err.Error())
+	}
+
+	if isBinary(head) {
+		return "binary content"
+	}
+	return ""
+}
+
+// isBinary reports whether head holds a NUL byte or more than 10% control
+// characters that don't appear in text logs
+func isBinary(head []byte) bool {
+	control := 0
+	for _, b := range head {
+		switch {
+		case b == 0:
+			return true
+		case b == '\t', b == '\n', b == '\r', b == '\f', b == '\v', b == 0x1b:
+		case b < 0x20, b == 0x7f:
+			control++
+		}
+	}
+	return control*10 > len(head)
+}
+
+// PrintLogFileDecisions lists the files of a log directory that match a pattern,
+// telling whether they would be parsed and why
+func PrintLogFileDecisions(root string, files LogFiles) {
+	dryRunMu.Lock()
+	defer dryRunMu.Unlock()
+
+	config := renderer.ColorizedConfig{
+		Header: renderer.Tint{
+			FG: renderer.Colors{color.FgWhite, color.Bold},
+		},
+		Column: renderer.Tint{
+			FG: renderer.Colors{color.FgWhite},
+		},
+		Border: renderer.Tint{
+			FG: renderer.Colors{color.FgHiBlack},
+		},
+	}
+
+	table := tablewriter.NewTable(os.Stdout,
+		tablewriter.WithRenderer(renderer.NewColorized(config)),
+	)
+
+	table.Header("File", "Action", "Reason")
+
+	parse := color.New(color.FgGreen).SprintFunc()
+	skip := color.New(color.FgYellow).SprintFunc()
+
+	parsed := 0
+	for _, decision := range files.Decisions {
+		action := skip("skip")
+		if decision.Parsed {
+			action = parse("parse")
+			parsed++
+		}
+		table.Append(decision.Path, action, decision.Reason)
+	}
+
+	table.Render()
+
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Dry run of %s: %d files would be parsed, %d skipped, %d match no include pattern}}::green",
+		time.Now().Format(time.Stamp), root, parsed, len(files.Decisions)-parsed, files.Unmatched))
+}
@@ -0,0 +1,159 @@
+package parsers
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLogBaseName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"kern.log", "kern.log"},
+		{"kern.log.1", "kern.log"},
+		{"kern.log.1.gz", "kern.log"},
+		{"syslog.2.xz", "syslog"},
+		{"messages-20240101", "messages"},
+		{"messages-20240101.bz2", "messages"},
+		{"daemon.log.3.zst", "daemon.log"},
+		{"dmesg.0.lz4", "dmesg"},
+		{"syslog.gz", "syslog"},
+		// Only the trailing numbers are rotation suffixes
+		{"kern.log.old", "kern.log.old"},
+		{"log-2024.txt", "log-2024.txt"},
+		{"20240101", "20240101"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := logBaseName(tt.name); got != tt.want {
+				t.Errorf("logBaseName(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogPatternMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		want    bool
+	}{
+		// Names, in any directory, and their rotated copies
+		{"kern.log", "kern.log", true},
+		{"kern.log", "kern.log.2.gz", true},
+		{"kern.log", "old/kern.log.1", true},
+		{"kern.log", "kern.log.old", false},
+		{"syslog", "syslog-ng.log", false},
+		{"messages", "messages-20240101", true},
+		{"*.log", "apt/history.log", true},
+		{"*.log", "apt/history.log.1.gz", true},
+		// Globs with a '/' match the relative path
+		{"apt/*.log", "apt/history.log", true},
+		{"apt/*.log", "apt/term.log.3.gz", true},
+		{"apt/*.log", "history.log", false},
+		{"apt/*.log", "old/apt/history.log", false},
+		// Regexps match the relative path, unanchored
+		{"re:^remote/.*\\.log$", "remote/web1.log", true},
+		{"re:^remote/.*\\.log$", "remote/web1.log.1", true},
+		{"re:^remote/", "kern.log", false},
+		{"re:web", "remote/web1.log", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.rel, func(t *testing.T) {
+			pattern, err := parseLogPattern(tt.pattern)
+			if err != nil {
+				t.Fatalf("parseLogPattern: %v", err)
+			}
+			if got := pattern.matches(tt.rel); got != tt.want {
+				t.Errorf("%q matches %q = %v, want %v", tt.pattern, tt.rel, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLogPatternErrors(t *testing.T) {
+	for _, text := range []string{"[kern", "re:(kern", "re:*"} {
+		if _, err := parseLogPattern(text); err == nil {
+			t.Errorf("parseLogPattern(%q) succeeded, want an error", text)
+		}
+	}
+}
+
+func TestLogSelectorClassify(t *testing.T) {
+	selector, err := NewLogSelector(nil, []string{"installer", "*.gz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rel    string
+		kind   logFileKind
+		reason string
+	}{
+		{"kern.log", logFile, "matches kern.log"},
+		{"syslog.1", logFile, "matches syslog"},
+		{"syslog.2.gz", excludedFile, "excluded by *.gz"},
+		{"installer/syslog", excludedFile, "directory excluded by installer"},
+		{"installer/logs/syslog", excludedFile, "directory excluded by installer"},
+		{"wtmp", loginFile, "login records (wtmp/btmp)"},
+		{"btmp.1", loginFile, "login records (wtmp/btmp)"},
+		{"apt/history.log", unmatchedFile, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rel, func(t *testing.T) {
+			kind, reason := selector.classify(tt.rel)
+			if kind != tt.kind || reason != tt.reason {
+				t.Errorf("classify(%q) = %v, %q, want %v, %q", tt.rel, kind, reason, tt.kind, tt.reason)
+			}
+		})
+	}
+}
+
+// TestWalkLocal selects the files of testdata/varlog: a log, a compressed
+// rotated log, a wtmp file, an empty and a binary log, a log in an excluded
+// directory and a file no pattern matches
+func TestWalkLocal(t *testing.T) {
+	root := filepath.Join("testdata", "varlog")
+	selector, err := NewLogSelector(nil, []string{"installer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := selector.WalkLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := func(rel string) string { return filepath.Join(root, rel) }
+	if want := []string{at("kern.log"), at("syslog.2.gz")}; !reflect.DeepEqual(files.Logs, want) {
+		t.Errorf("Logs = %q, want %q", files.Logs, want)
+	}
+	if want := []string{at("wtmp")}; !reflect.DeepEqual(files.LoginFiles, want) {
+		t.Errorf("LoginFiles = %q, want %q", files.LoginFiles, want)
+	}
+	for _, log := range files.Logs {
+		if _, ok := files.ModTimes[log]; !ok {
+			t.Errorf("no modification time for %s", log)
+		}
+	}
+	if files.Unmatched != 1 {
+		t.Errorf("Unmatched = %d, want 1", files.Unmatched)
+	}
+
+	want := []LogFileDecision{
+		{Path: at("dmesg"), Reason: "empty file"},
+		{Path: at("installer") + "/", Reason: "directory excluded by installer"},
+		{Path: at("kern.log"), Parsed: true, Reason: "matches kern.log"},
+		{Path: at("syslog.1"), Reason: "binary content"},
+		{Path: at("syslog.2.gz"), Parsed: true, Reason: "matches syslog"},
+		{Path: at("wtmp"), Parsed: true, Reason: "login records (wtmp/btmp)"},
+	}
+	if !reflect.DeepEqual(files.Decisions, want) {
+		t.Errorf("Decisions = %+v, want %+v", files.Decisions, want)
+	}
+}
@@ -2,13 +2,14 @@ package parsers
 
 import (
 	"bufio"
-	"compress/gzip"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -23,110 +24,196 @@ import (
 // Compiled regular expressions for log parsing (performance optimization)
 var (
 	// parseLine regexes
-	reUSB        = regexp.MustCompile(`(?:]|:) usb (.*?): `)
-	reUSBStorage = regexp.MustCompile(`(?:]|:) usb-storage (.*?): `)
-	reTimestamp  = regexp.MustCompile(`(\S+\s+\d+\s\d{2}:\d{2}:\d{2})`)
-
-	// CollectEventsData regexes
-	reVid             = regexp.MustCompile(`idVendor=(\w+)`)
-	rePid             = regexp.MustCompile(`idProduct=(\w+)`)
-	reProduct         = regexp.MustCompile(`Product: (.*?$)`)
-	reManufacture     = regexp.MustCompile(`Manufacturer: (.*?$)`)
-	reSerial          = regexp.MustCompile(`SerialNumber: (.*?$)`)
-	rePort            = regexp.MustCompile(`(?m)usb (.*[0-9]):`)
-	reUSBStorageMatch = regexp.MustCompile(`usb-storage (.*?$)`)
-	reHost            = regexp.MustCompile(`(.*:\d{2}\s)(.*) (.*:\s\[)`)
+	reUSB        = regexp.MustCompile(`(?:]|:|-) usb (.*?): `)
+	reUSBStorage = regexp.MustCompile(`(?:]|:|-) usb-storage (.*?): `)
+	reTimestamp  = regexp.MustCompile(`^(\S+\s+\d+\s\d{2}:\d{2}:\d{2})\s+(\S+)`)
+
+	// RFC 3339 (rsyslog high precision) and RFC 5424 headers with optional <PRI>VERSION
+	reISOTimestamp = regexp.MustCompile(`^(?:<\d{1,3}>\d{1,2}\s+)?(\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d{1,9})?(Z|[+-]\d{2}:?\d{2})?)\s+(\S+)`)
+	// Kernel printk stamp: [ 1234.567890]
+	reKernelStamp = regexp.MustCompile(`\[\s*(\d+)\.(\d{1,9})\]`)
 )
 
-func CollectLogs(params data.ParseParams) ([]string, error) {
-	var files []string
-
+// CollectLogs returns the log files under params.LogPath selected by the
+// include and exclude patterns, and the wtmp and btmp files found with them
+func CollectLogs(params data.ParseParams) (LogFiles, error) {
 	path, err := utils.ExpandPath(params.LogPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to expand path %s: %w", params.LogPath, err)
+		return LogFiles{}, fmt.Errorf("failed to expand path %s: %w", params.LogPath, err)
 	}
 
-	err = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			// Skip files/directories that we can't access
-			_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: skipping %s: %s}}::yellow", time.Now().Format(time.Stamp), path, err.Error()))
-			return nil
-		}
-		switch {
-		case strings.Contains(path, "syslog"):
-			files = append(files, path)
-		case strings.Contains(path, "messages"):
-			files = append(files, path)
-		case strings.Contains(path, "kern"):
-			files = append(files, path)
-		case strings.Contains(path, "daemon"):
-			files = append(files, path)
-		}
-		return nil
-	})
+	selector, err := NewLogSelector(params.LogInclude, params.LogExclude)
 	if err != nil {
-		return nil, fmt.Errorf("failed to walk directory %s: %w", path, err)
+		return LogFiles{}, err
 	}
 
-	if len(files) == 0 {
-		return nil, fmt.Errorf("no log files found in %s", path)
+	files, err := selector.WalkLocal(filepath.Clean(path))
+	if err != nil {
+		return LogFiles{}, fmt.Errorf("failed to walk directory %s: %w", path, err)
+	}
+
+	if len(files.Logs) == 0 && len(files.LoginFiles) == 0 && !params.DryRun {
+		return LogFiles{}, fmt.Errorf("no log files found in %s", path)
 	}
 
 	return files, nil
 }
 
-func parseLine(scanner *bufio.Scanner) []data.LogEvent {
-	var logEvents []data.LogEvent
+// lineScanner scans the lines of a log and tracks the line number and byte
+// offset of the current line, the provenance recorded on its events
+type lineScanner struct {
+	*bufio.Scanner
+	line   int
+	offset int64 // of the current line
+	next   int64 // of the line after it
+}
+
+func newLineScanner(r io.Reader) *lineScanner {
+	s := &lineScanner{Scanner: bufio.NewScanner(r)}
 
 	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
+	s.Buffer(buf, 1024*1024)
+	s.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if token != nil {
+			s.line++
+			s.offset = s.next
+		}
+		s.next += int64(advance)
+		return advance, token, err
+	})
+
+	return s
+}
+
+// event returns the USB or session event of the current line, read from source
+func (s *lineScanner) event(source string) (data.LogEvent, bool) {
+	logLine := s.Text()
+	eventType := classifyLine(logLine)
+	if eventType == data.Unknown {
+		return data.LogEvent{}, false
+	}
+
+	header := parseHeader(logLine)
+	return data.LogEvent{
+		Date:       header.date,
+		ActionType: eventType,
+		LogLine:    logLine,
+		Source:     source,
+		Line:       s.line,
+		Offset:     s.offset,
+		Host:       header.host,
+		Monotonic:  header.monotonic,
+	}, true
+}
+
+// parseLine collects USB events from scanner, source is the file being read
+func parseLine(scanner *lineScanner, source string) []data.LogEvent {
+	var logEvents []data.LogEvent
 
 	for scanner.Scan() {
-		logLine := scanner.Text()
-		if reUSB.MatchString(logLine) || reUSBStorage.MatchString(logLine) {
-			logTime := utils.Submatch(reTimestamp, logLine, 1)
-			dateTime := utils.TimeStampToTime(logTime)
-			eventType := utils.GetActionType(logLine)
-
-			if eventType != data.Unknown {
-				logEvents = append(logEvents, data.LogEvent{
-					Date:       dateTime,
-					ActionType: eventType,
-					LogLine:    logLine,
-				})
-			}
+		if event, ok := scanner.event(source); ok {
+			logEvents = append(logEvents, event)
 		}
 	}
 	return logEvents
 }
-func parseUGzipped(path string) []data.LogEvent {
-	file, err := os.Open(path)
-	if err != nil {
-		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Cannot read log file: %s}}::red", time.Now().Format(time.Stamp), path))
-		return []data.LogEvent{}
+
+// logHeader holds the values parsed from the beginning of a log line
+type logHeader struct {
+	date      time.Time
+	host      string
+	monotonic time.Duration
+}
+
+// parseHeader extracts timestamp, host and kernel monotonic stamp of a line.
+// RFC 3339/5424 timestamps keep their timezone offset and sub-second precision.
+// Classic BSD syslog stamps have no year, it is resolved later by
+// utils.ResolveYears once all files have been read. Lines carrying only the
+// kernel stamp (dmesg output) get no wall-clock time.
+func parseHeader(logLine string) logHeader {
+	var header logHeader
+
+	if m := reKernelStamp.FindStringSubmatch(logLine); m != nil {
+		header.monotonic = parseMonotonic(m[1], m[2])
 	}
 
-	defer file.Close()
+	if m := reISOTimestamp.FindStringSubmatch(logLine); m != nil {
+		header.date = parseISOTimestamp(m[1], m[2] != "")
+		header.host = m[3]
+		return header
+	}
 
-	return parseLine(bufio.NewScanner(file))
+	if m := reTimestamp.FindStringSubmatch(logLine); m != nil {
+		dateTime, err := utils.ParseSyslogStamp(m[1])
+		if err != nil {
+			_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Failed to parse timestamp '%s': %s}}::red", time.Now().Format(time.Stamp), m[1], err.Error()))
+			return header
+		}
+		header.date = dateTime
+		header.host = m[2]
+	}
+
+	return header
 }
 
-func parseGzipped(path string) []data.LogEvent {
-	file, err := os.Open(path)
+func parseISOTimestamp(stamp string, hasZone bool) time.Time {
+	stamp = strings.Replace(stamp, " ", "T", 1)
+	stamp = strings.Replace(stamp, ",", ".", 1)
+
+	var dateTime time.Time
+	var err error
+	if hasZone {
+		// Offsets without a colon (+0100) are valid in ISO 8601 but not in RFC 3339
+		if n := len(stamp); n > 5 && stamp[n-5] != ':' && (stamp[n-5] == '+' || stamp[n-5] == '-') {
+			stamp = stamp[:n-2] + ":" + stamp[n-2:]
+		}
+		dateTime, err = time.Parse(time.RFC3339Nano, stamp)
+	} else {
+		dateTime, err = time.ParseInLocation("2006-01-02T15:04:05.999999999", stamp, time.Local)
+	}
 	if err != nil {
-		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Cannot read log file: %s}}::red", time.Now().Format(time.Stamp), path))
-		return []data.LogEvent{}
+		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Failed to parse timestamp '%s': %s}}::red", time.Now().Format(time.Stamp), stamp, err.Error()))
+		return time.Time{}
+	}
+
+	return dateTime
+}
+
+func parseMonotonic(seconds, fraction string) time.Duration {
+	secs, err := strconv.ParseInt(seconds, 10, 64)
+	if err != nil {
+		return 0
+	}
+	// Right-pad the fraction to nanoseconds: "5678" -> 567800000
+	frac, err := strconv.ParseInt((fraction + "000000000")[:9], 10, 64)
+	if err != nil {
+		return 0
 	}
-	defer file.Close()
+	return time.Duration(secs)*time.Second + time.Duration(frac)
+}
 
-	gz, err := gzip.NewReader(file)
+// parseLogFile collects the USB events of a local log file, decompressing it
+// when it is compressed
+func parseLogFile(path string) []data.LogEvent {
+	reader, err := utils.OpenDecompressed(path, openLocal)
 	if err != nil {
-		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Cannot create gzip reader for %s: %s}}::red", time.Now().Format(time.Stamp), path, err.Error()))
+		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Cannot read log file %s: %s}}::red", time.Now().Format(time.Stamp), path, err.Error()))
 		return []data.LogEvent{}
 	}
-	defer gz.Close()
+	defer reader.Close()
+
+	scanner := newLineScanner(reader)
+	events := parseLine(scanner, path)
+	if err := scanner.Err(); err != nil {
+		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: scanner error for %s: %s}}::yellow", time.Now().Format(time.Stamp), path, err.Error()))
+	}
+	return events
+}
 
-	return parseLine(bufio.NewScanner(gz))
+// openLocal opens a local file for utils.OpenDecompressed
+func openLocal(path string) (io.ReadCloser, error) {
+	return os.Open(path)
 }
 
 // fileJob represents a file parsing job
@@ -290,17 +377,10 @@ func parseWorker(ctx context.Context, id int, jobs <-chan fileJob, results chan<
 		var events []data.LogEvent
 		var err error
 
-		switch filepath.Ext(job.path) {
-		case ".gz":
-			events = parseGzipped(job.path)
-		default:
-			events = parseUGzipped(job.path)
-		}
-
-		// Check if parsing had errors (empty result might indicate error)
-		if len(events) == 0 {
-			// This is not necessarily an error, file might just be empty
-			// but parseGzipped/parseUGzipped don't return errors
+		if IsJournalFile(job.path) {
+			events = parseJournal(job.path)
+		} else {
+			events = parseLogFile(job.path)
 		}
 
 		// Send result with context support
@@ -342,11 +422,10 @@ func ParseFilesSequential(ctx context.Context, files []string) []data.LogEvent {
 		default:
 		}
 
-		switch filepath.Ext(file) {
-		case ".gz":
-			recordTypes = append(recordTypes, parseGzipped(file)...)
-		default:
-			recordTypes = append(recordTypes, parseUGzipped(file)...)
+		if IsJournalFile(file) {
+			recordTypes = append(recordTypes, parseJournal(file)...)
+		} else {
+			recordTypes = append(recordTypes, parseLogFile(file)...)
 		}
 	}
 
@@ -450,35 +529,17 @@ func (sp *StreamingParser) streamWorker(id int, jobs <-chan string, wg *sync.Wai
 
 // streamFile parses a single file and streams events
 func (sp *StreamingParser) streamFile(path string) error {
-	var scanner *bufio.Scanner
-
-	if filepath.Ext(path) == ".gz" {
-		file, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-
-		gz, err := gzip.NewReader(file)
-		if err != nil {
-			return err
-		}
-		defer gz.Close()
-
-		scanner = bufio.NewScanner(gz)
-	} else {
-		file, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
+	if IsJournalFile(path) {
+		return readJournal(path, sp.emit)
+	}
 
-		scanner = bufio.NewScanner(file)
+	reader, err := utils.OpenDecompressed(path, openLocal)
+	if err != nil {
+		return err
 	}
+	defer reader.Close()
 
-	// Configure scanner for large lines
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
+	scanner := newLineScanner(reader)
 
 	// Stream events line by line
 	for scanner.Scan() {
@@ -489,28 +550,10 @@ func (sp *StreamingParser) streamFile(path string) error {
 		default:
 		}
 
-		logLine := scanner.Text()
-
 		// Check if line contains USB events
-		if reUSB.MatchString(logLine) || reUSBStorage.MatchString(logLine) {
-			logTime := utils.Submatch(reTimestamp, logLine, 1)
-			dateTime := utils.TimeStampToTime(logTime)
-			eventType := utils.GetActionType(logLine)
-
-			if eventType != data.Unknown {
-				event := data.LogEvent{
-					Date:       dateTime,
-					ActionType: eventType,
-					LogLine:    logLine,
-				}
-
-				// Send event to channel with context support
-				select {
-				case <-sp.ctx.Done():
-					return sp.ctx.Err()
-				case sp.events <- event:
-					sp.eventsCount.Add(1)
-				}
+		if event, ok := scanner.event(path); ok {
+			if err := sp.emit(event); err != nil {
+				return err
 			}
 		}
 	}
@@ -518,6 +561,17 @@ func (sp *StreamingParser) streamFile(path string) error {
 	return scanner.Err()
 }
 
+// emit sends an event to the events channel with context support
+func (sp *StreamingParser) emit(event data.LogEvent) error {
+	select {
+	case <-sp.ctx.Done():
+		return sp.ctx.Err()
+	case sp.events <- event:
+		sp.eventsCount.Add(1)
+	}
+	return nil
+}
+
 // Events returns the events channel for consumption
 func (sp *StreamingParser) Events() <-chan data.LogEvent {
 	return sp.events
@@ -579,6 +633,7 @@ func ParseFilesStreaming(ctx context.Context, files []string, workers int) []dat
 	defer progressTicker.Stop()
 
 	lastFileCount := int64(0)
+	parseErrors := parser.Errors()
 
 	collecting := true
 	for collecting {
@@ -598,7 +653,12 @@ func ParseFilesStreaming(ctx context.Context, files []string, workers int) []dat
 			}
 			allEvents = append(allEvents, event)
 
-		case err := <-parser.Errors():
+		case err, ok := <-parseErrors:
+			if !ok {
+				// Errors channel closed, stop selecting on it
+				parseErrors = nil
+				break
+			}
 			if bar != nil {
 				bar.Clear()
 			}
@@ -638,100 +698,6 @@ func ParseFilesStreaming(ctx context.Context, files []string, workers int) []dat
 	return allEvents
 }
 
-// Parser states for USB device event collection
-const (
-	stateNone = iota
-	stateNewDevice
-	stateExpectProduct
-	stateExpectManufacturer
-	stateExpectSerial
-	stateExpectStorage
-)
-
-// CollectEventsData collect data from events logs.
-func CollectEventsData(events []data.LogEvent) []data.Event {
-	var currentIndex = -1
-	var state = stateNone
-	allEvents := make([]data.Event, 0)
-
-	for _, event := range events {
-		switch event.ActionType {
-		case data.Connected:
-			// Check for new USB device connection
-			if strings.Contains(event.LogLine, "New USB device found, ") {
-				host := utils.Submatch(reHost, event.LogLine, 2)
-				vid := utils.Submatch(reVid, event.LogLine, 1)
-				pid := utils.Submatch(rePid, event.LogLine, 1)
-				port := utils.Submatch(rePort, event.LogLine, 1)
-
-				allEvents = append(allEvents, data.Event{
-					ConnectedTime:     event.Date,
-					Host:              host,
-					Vid:               vid,
-					Pid:               pid,
-					ProductName:       "None",
-					ManufacturerName:  "None",
-					SerialNumber:      "None",
-					ConnectionPort:    port,
-					DisconnectionTime: time.Now(),
-				})
-
-				currentIndex++
-				state = stateExpectProduct
-				continue
-			}
-
-			// Process device attributes based on current state
-			if currentIndex < 0 || state == stateNone {
-				continue
-			}
-
-			switch state {
-			case stateExpectProduct:
-				if prod := utils.Submatch(reProduct, event.LogLine, 1); prod != "" {
-					allEvents[currentIndex].ProductName = prod
-					state = stateExpectManufacturer
-				} else {
-					state = stateNone
-				}
-
-			case stateExpectManufacturer:
-				if manufacture := utils.Submatch(reManufacture, event.LogLine, 1); manufacture != "" {
-					allEvents[currentIndex].ManufacturerName = manufacture
-					state = stateExpectSerial
-				} else {
-					state = stateNone
-				}
-
-			case stateExpectSerial:
-				if serial := utils.Submatch(reSerial, event.LogLine, 1); serial != "" {
-					allEvents[currentIndex].SerialNumber = serial
-					state = stateExpectStorage
-				} else {
-					state = stateNone
-				}
-
-			case stateExpectStorage:
-				if storage := utils.Submatch(reUSBStorageMatch, event.LogLine, 1); storage != "" {
-					allEvents[currentIndex].IsMassStorage = true
-				}
-				state = stateNone
-			}
-
-		case data.Disconnected:
-			port := utils.Submatch(rePort, event.LogLine, 1)
-			if port != "" {
-				for i := range allEvents {
-					if allEvents[i].ConnectionPort == port {
-						allEvents[i].DisconnectionTime = event.Date
-					}
-				}
-			}
-		}
-	}
-	return allEvents
-}
-
 // GetMemStats returns current memory statistics
 func GetMemStats() runtime.MemStats {
 	var m runtime.MemStats
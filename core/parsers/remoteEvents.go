@@ -1,15 +1,16 @@
 package parsers
 
 import (
-	"bufio"
 	"bytes"
-	"compress/gzip"
+	"context"
 	"fmt"
-	"path/filepath"
+	"io"
+	"path"
 	"strings"
 	"time"
 
 	"github.com/i582/cfmt/cmd/cfmt"
+	"github.com/pixfid/luft/core/database"
 	"github.com/pixfid/luft/core/utils"
 	"github.com/pixfid/luft/data"
 	"github.com/pkg/sftp"
@@ -18,34 +19,65 @@ import (
 )
 
 func RemoteEvents(params data.ParseParams) error {
+	events, scan, err := collectRemoteEvents(params, true)
+	if err != nil || params.DryRun {
+		return err
+	}
+	if params.SaveToDB {
+		saveScans(params, scanEvents{scan: scan, events: events})
+	}
+
+	filteredEvents := utils.FilterEvents(params, events)
+	clearEvents := utils.RemoveDuplicates(filteredEvents)
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Filter and remove duplicates complete, %d clear events found}}::green", time.Now().Format(time.Stamp), len(clearEvents)))
+
+	return reportEvents(params, clearEvents)
+}
+
+// collectRemoteEvents reads the USB events of the host set in params over SFTP,
+// and returns them unfiltered with the scan to save them under.
+// Without showProgress, as when several hosts are scanned at once, per-file
+// progress is not printed.
+func collectRemoteEvents(params data.ParseParams, showProgress bool) ([]data.Event, database.Scan, error) {
+	startedAt := time.Now()
+
 	// Check context before starting
 	select {
 	case <-params.Ctx.Done():
-		return params.Ctx.Err()
+		return nil, database.Scan{}, params.Ctx.Err()
 	default:
 	}
 
-	// Get SSH authentication methods
-	authMethods, err := utils.GetSSHAuthMethods(params.SSHKeyPath, params.Password)
-	if err != nil {
-		return fmt.Errorf("failed to setup authentication: %w", err)
-	}
-
-	// Get host key callback
-	hostKeyCallback, err := utils.GetHostKeyCallback(params.InsecureSSH)
-	if err != nil {
-		return fmt.Errorf("failed to setup host key verification (hint: use --insecure-ssh to skip verification, NOT RECOMMENDED): %w", err)
+	var jumpHosts []utils.SSHEndpoint
+	for _, jump := range params.JumpHosts {
+		jumpHosts = append(jumpHosts, utils.SSHEndpoint{
+			Host:     jump.Host,
+			Port:     jump.Port,
+			User:     jump.Login,
+			KeyPath:  jump.SSHKeyPath,
+			Password: jump.Password,
+			Insecure: jump.InsecureSSH,
+		})
 	}
 
-	config := &ssh.ClientConfig{
-		User:            params.Login,
-		Auth:            authMethods,
-		HostKeyCallback: hostKeyCallback,
-		Timeout:         time.Duration(params.SSHTimeout) * time.Second,
+	// Host aliases, users, ports, keys and jump hosts may come from ~/.ssh/config
+	endpoint := utils.ResolveSSHEndpoint(utils.SSHEndpoint{
+		Host:      params.IP,
+		Port:      params.Port,
+		User:      params.Login,
+		KeyPath:   params.SSHKeyPath,
+		Password:  params.Password,
+		JumpHosts: jumpHosts,
+		Timeout:   time.Duration(params.SSHTimeout) * time.Second,
+		Insecure:  params.InsecureSSH,
+	})
+
+	via := ""
+	if route := endpoint.JumpRoute(); route != "" {
+		via = " via " + route
 	}
-
-	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Connecting to %s:%s with timeout %ds...}}::green",
-		time.Now().Format(time.Stamp), params.IP, params.Port, params.SSHTimeout))
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Connecting to %s@%s%s with timeout %ds...}}::green",
+		time.Now().Format(time.Stamp), endpoint.User, endpoint.Address(), via, params.SSHTimeout))
 
 	// Dial with context support - use goroutine to allow cancellation
 	type dialResult struct {
@@ -54,29 +86,35 @@ func RemoteEvents(params data.ParseParams) error {
 	}
 	dialChan := make(chan dialResult, 1)
 	go func() {
-		conn, err := ssh.Dial("tcp", fmt.Sprintf("%s:%s", params.IP, params.Port), config)
+		conn, err := utils.DialSSH(endpoint)
 		dialChan <- dialResult{conn: conn, err: err}
 	}()
 
 	var conn *ssh.Client
 	select {
 	case <-params.Ctx.Done():
-		return params.Ctx.Err()
+		// Close the connection the dial may still make
+		go func() {
+			if result := <-dialChan; result.err == nil {
+				result.conn.Close()
+			}
+		}()
+		return nil, database.Scan{}, params.Ctx.Err()
 	case result := <-dialChan:
 		if result.err != nil {
-			return fmt.Errorf("failed to connect to %s:%s: %w", params.IP, params.Port, result.err)
+			return nil, database.Scan{}, result.err
 		}
 		conn = result.conn
 	}
 	defer conn.Close()
 
-	// Monitor context and close connection if cancelled
-	go func() {
-		<-params.Ctx.Done()
+	// Close the connection if the context is cancelled mid-transfer
+	stop := context.AfterFunc(params.Ctx, func() {
 		conn.Close()
-	}()
+	})
+	defer stop()
 
-	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Successfully connected to remote host}}::green", time.Now().Format(time.Stamp)))
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Successfully connected to %s}}::green", time.Now().Format(time.Stamp), endpoint.Address()))
 
 	hostName := func(cmd string) string {
 		session, err := conn.NewSession()
@@ -98,19 +136,34 @@ func RemoteEvents(params data.ParseParams) error {
 
 	client, err := sftp.NewClient(conn)
 	if err != nil {
-		return fmt.Errorf("failed to create SFTP client: %w", err)
+		return nil, database.Scan{}, fmt.Errorf("failed to create SFTP client: %w", err)
 	}
 	defer client.Close()
 
-	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Starting on: }}::green {{%s}}::red", time.Now().Format(time.Stamp), hostName(`hostname -f`)))
+	remoteHostName := hostName(`hostname -f`)
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Starting on: }}::green {{%s}}::red", time.Now().Format(time.Stamp), remoteHostName))
 	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] User login: }}::green {{%s}}::red", time.Now().Format(time.Stamp), hostName(`who | grep " :0" | cut -d " " -f1`)))
 
-	readFile := func(path []string, client *sftp.Client) error {
+	logDir := params.RemoteLogPath
+	if logDir == "" {
+		logDir = "/var/log"
+	}
+	logDir = path.Clean(logDir)
+
+	selector, err := NewLogSelector(params.LogInclude, params.LogExclude)
+	if err != nil {
+		return nil, database.Scan{}, err
+	}
+	// Selected logs, with the wtmp and btmp files read for the users logged in
+	// when devices were connected
+	var files LogFiles
+
+	readFile := func(path []string, client *sftp.Client) ([]data.Event, error) {
 		var recordTypes []data.LogEvent
 
 		// Create progress bar for remote file processing (only if >= 5 files)
 		var bar *progressbar.ProgressBar
-		if len(path) >= 5 {
+		if showProgress && len(path) >= 5 {
 			bar = progressbar.NewOptions(len(path),
 				progressbar.OptionSetDescription("Processing remote files"),
 				progressbar.OptionSetWidth(40),
@@ -135,60 +188,33 @@ func RemoteEvents(params data.ParseParams) error {
 				if bar != nil {
 					bar.Clear()
 				}
-				return params.Ctx.Err()
+				return nil, params.Ctx.Err()
 			default:
 			}
 
 			// Process each file in a separate function to ensure proper resource cleanup
 			func(filePath string) {
-				if filepath.Ext(filePath) == ".gz" {
-					file, err := client.Open(filePath)
-					if err != nil {
-						_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: failed to open file %s: %s}}::yellow", time.Now().Format(time.Stamp), filePath, err.Error()))
-						return
-					}
-					defer file.Close()
-
-					gz, err := gzip.NewReader(file)
-					if err != nil {
-						_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: failed to create gzip reader for %s: %s}}::yellow", time.Now().Format(time.Stamp), filePath, err.Error()))
-						return
-					}
-					defer gz.Close()
-
-					scanner := bufio.NewScanner(gz)
-					buf := make([]byte, 0, 64*1024)
-					scanner.Buffer(buf, 1024*1024)
-
-					recordTypes = append(recordTypes, parseLine(scanner)...)
-
-					if err := scanner.Err(); err != nil {
-						_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: scanner error for %s: %s}}::yellow", time.Now().Format(time.Stamp), filePath, err.Error()))
-					}
-				} else {
-					file, err := client.Open(filePath)
-					if err != nil {
-						_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: failed to open file %s: %s}}::yellow", time.Now().Format(time.Stamp), filePath, err.Error()))
-						return
-					}
-					defer file.Close()
-
-					scanner := bufio.NewScanner(file)
-					buf := make([]byte, 0, 64*1024)
-					scanner.Buffer(buf, 1024*1024)
-
-					recordTypes = append(recordTypes, parseLine(scanner)...)
-
-					if err := scanner.Err(); err != nil {
-						_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: scanner error for %s: %s}}::yellow", time.Now().Format(time.Stamp), filePath, err.Error()))
-					}
+				reader, err := utils.OpenDecompressed(filePath, func(path string) (io.ReadCloser, error) {
+					return client.Open(path)
+				})
+				if err != nil {
+					_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: failed to open file %s: %s}}::yellow", time.Now().Format(time.Stamp), filePath, err.Error()))
+					return
+				}
+				defer reader.Close()
+
+				scanner := newLineScanner(reader)
+				recordTypes = append(recordTypes, parseLine(scanner, filePath)...)
+
+				if err := scanner.Err(); err != nil {
+					_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: scanner error for %s: %s}}::yellow", time.Now().Format(time.Stamp), filePath, err.Error()))
 				}
 			}(s)
 
 			// Update progress bar
 			if bar != nil {
 				bar.Add(1)
-			} else if len(path) < 5 {
+			} else if showProgress {
 				// Show text progress for small file counts
 				_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Processed %d/%d remote files...}}::cyan",
 					time.Now().Format(time.Stamp), idx+1, len(path)))
@@ -201,58 +227,52 @@ func RemoteEvents(params data.ParseParams) error {
 		}
 
 		if len(recordTypes) == 0 {
-			return fmt.Errorf("no USB events found in remote log files")
+			return nil, fmt.Errorf("no USB events found in remote log files")
 		}
 
+		utils.ResolveYears(recordTypes, files.ModTimes, params.ReferenceDate)
+
 		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Found %d events records}}::green", time.Now().Format(time.Stamp), len(recordTypes)))
 		events := CollectEventsData(recordTypes)
 		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Parsed %d events}}::green", time.Now().Format(time.Stamp), len(events)))
-		filteredEvents := utils.FilterEvents(params, events)
-		clearEvents := utils.RemoveDuplicates(filteredEvents)
-		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Filter and remove duplicates complete, %d clear events found}}::green", time.Now().Format(time.Stamp), len(clearEvents)))
 
-		if params.Export {
-			if err := utils.ExportData(clearEvents, params.Format, params.FileName); err != nil {
-				return fmt.Errorf("failed to export events: %w", err)
-			}
-		} else {
-			_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Representation: table}}::green", time.Now().Format(time.Stamp)))
-			utils.PrintEvents(clearEvents)
-		}
+		annotateUsers(events, append(CollectSessions(recordTypes), readLoginFiles(files.LoginFiles, func(path string) (io.ReadCloser, error) {
+			return client.Open(path)
+		})...))
 
-		return nil
+		return utils.RemoveDuplicates(events), nil
 	}
 
-	var files []string
-
-	readDir, err := client.ReadDir("/var/log")
+	// The same selection as local scans, walking the remote tree over SFTP
+	files, err = selector.Walk(logDir, client.Walk(logDir), func(path string) (io.ReadCloser, error) {
+		return client.Open(path)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to read remote /var/log directory: %w", err)
+		return nil, database.Scan{}, fmt.Errorf("failed to read remote %s directory: %w", logDir, err)
 	}
-
-	for _, fileInfo := range readDir {
-		if !fileInfo.IsDir() {
-			if strings.Contains(fileInfo.Name(), "syslog") {
-				files = append(files, fmt.Sprintf("/var/log/%s", fileInfo.Name()))
-			} else if strings.Contains(fileInfo.Name(), "messages") {
-				files = append(files, fmt.Sprintf("/var/log/%s", fileInfo.Name()))
-			} else if strings.Contains(fileInfo.Name(), "kern") {
-				files = append(files, fmt.Sprintf("/var/log/%s", fileInfo.Name()))
-			} else if strings.Contains(fileInfo.Name(), "daemon") {
-				files = append(files, fmt.Sprintf("/var/log/%s", fileInfo.Name()))
-			}
-		}
+	if params.DryRun {
+		PrintLogFileDecisions(remoteHostName+":"+logDir, files)
+		return nil, database.Scan{}, nil
 	}
 
-	if len(files) == 0 {
-		return fmt.Errorf("no relevant log files found in /var/log on remote host")
+	if len(files.Logs) == 0 && len(files.LoginFiles) == 0 {
+		return nil, database.Scan{}, fmt.Errorf("no relevant log files found in %s on remote host", logDir)
 	}
 
-	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Found %d log files to process}}::green", time.Now().Format(time.Stamp), len(files)))
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Found %d log files to process}}::green", time.Now().Format(time.Stamp), len(files.Logs)))
 
-	if err := readFile(files, client); err != nil {
-		return fmt.Errorf("failed to process remote log files: %w", err)
+	events, err := readFile(files.Logs, client)
+	if err != nil {
+		return nil, database.Scan{}, fmt.Errorf("failed to process remote log files: %w", err)
 	}
 
-	return nil
+	scan := database.Scan{
+		Source:     "remote",
+		Host:       remoteHostName,
+		LogPath:    logDir,
+		Files:      len(files.Logs),
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+	}
+	return events, scan, nil
 }
@@ -0,0 +1,176 @@
+package parsers
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/i582/cfmt/cmd/cfmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/olekukonko/tablewriter/renderer"
+	"github.com/pixfid/luft/core/database"
+	"github.com/pixfid/luft/core/utils"
+	"github.com/pixfid/luft/data"
+)
+
+// DefaultHostWorkers is the number of hosts a multi-host scan connects to at once
+const DefaultHostWorkers = 8
+
+// hostResult is the outcome of scanning one host of a multi-host scan
+type hostResult struct {
+	target   data.RemoteTarget
+	events   []data.Event
+	scan     database.Scan
+	err      error
+	duration time.Duration
+}
+
+// RemoteHostsEvents scans every host of params.Hosts, at most params.HostWorkers
+// at once, and reports their events together with the host each one came from.
+// A host that can't be scanned is listed in the summary and doesn't stop the others.
+func RemoteHostsEvents(params data.ParseParams) error {
+	numWorkers := params.HostWorkers
+	if numWorkers <= 0 {
+		numWorkers = DefaultHostWorkers
+	}
+	if numWorkers > len(params.Hosts) {
+		numWorkers = len(params.Hosts)
+	}
+
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Scanning %d remote hosts, %d at a time...}}::green",
+		time.Now().Format(time.Stamp), len(params.Hosts), numWorkers))
+
+	// Each worker writes the result of a host at its index, keeping the config order
+	results := make([]hostResult, len(params.Hosts))
+	jobs := make(chan int, len(params.Hosts))
+
+	var wg sync.WaitGroup
+	for w := 1; w <= numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = scanHost(params, params.Hosts[i])
+			}
+		}()
+	}
+
+	for i := range params.Hosts {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := params.Ctx.Err(); err != nil {
+		return err
+	}
+
+	printHostSummary(results)
+	if params.DryRun {
+		return nil
+	}
+
+	var events []data.Event
+	var scans []scanEvents
+	failed := 0
+	for _, result := range results {
+		if result.err != nil {
+			failed++
+			continue
+		}
+		events = append(events, result.events...)
+		scans = append(scans, scanEvents{scan: result.scan, events: result.events})
+	}
+	if failed == len(results) {
+		return fmt.Errorf("failed to scan any of the %d remote hosts", len(results))
+	}
+	if failed > 0 {
+		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: %d of %d remote hosts failed, their events are missing}}::yellow",
+			time.Now().Format(time.Stamp), failed, len(results)))
+	}
+	if params.SaveToDB {
+		saveScans(params, scans...)
+	}
+
+	filteredEvents := utils.FilterEvents(params, events)
+	clearEvents := utils.RemoveDuplicates(filteredEvents)
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Filter and remove duplicates complete, %d clear events found}}::green", time.Now().Format(time.Stamp), len(clearEvents)))
+
+	return reportEvents(params, clearEvents)
+}
+
+// scanHost collects the events of one host of a multi-host scan
+func scanHost(params data.ParseParams, target data.RemoteTarget) hostResult {
+	startedAt := time.Now()
+
+	params.Hosts = nil
+	params.IP = target.IP
+	params.Port = target.Port
+	params.Login = target.Login
+	params.Password = target.Password
+	params.SSHKeyPath = target.SSHKeyPath
+	params.SSHTimeout = target.SSHTimeout
+	params.InsecureSSH = target.InsecureSSH
+	params.RemoteLogPath = target.LogPath
+	params.JumpHosts = target.JumpHosts
+
+	events, scan, err := collectRemoteEvents(params, false)
+	if err != nil {
+		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: %s: %s}}::yellow", time.Now().Format(time.Stamp), target.Name, err.Error()))
+	}
+	for i := range events {
+		events[i].ScanHost = target.Name
+	}
+
+	return hostResult{
+		target:   target,
+		events:   events,
+		scan:     scan,
+		err:      err,
+		duration: time.Since(startedAt),
+	}
+}
+
+// printHostSummary prints whether each host of a multi-host scan succeeded
+func printHostSummary(results []hostResult) {
+	config := renderer.ColorizedConfig{
+		Header: renderer.Tint{
+			FG: renderer.Colors{color.FgWhite, color.Bold},
+		},
+		Column: renderer.Tint{
+			FG: renderer.Colors{color.FgWhite},
+		},
+		Border: renderer.Tint{
+			FG: renderer.Colors{color.FgHiBlack},
+		},
+	}
+
+	table := tablewriter.NewTable(os.Stdout,
+		tablewriter.WithRenderer(renderer.NewColorized(config)),
+	)
+
+	table.Header("Host", "Address", "Status", "Events", "Time", "Error")
+
+	ok := color.New(color.FgGreen).SprintFunc()
+	failed := color.New(color.FgHiRed).SprintFunc()
+
+	for _, result := range results {
+		status, errText := ok("ok"), ""
+		if result.err != nil {
+			status, errText = failed("failed"), result.err.Error()
+		}
+
+		table.Append(
+			result.target.Name,
+			fmt.Sprintf("%s:%s", result.target.IP, result.target.Port),
+			status,
+			fmt.Sprint(len(result.events)),
+			result.duration.Round(time.Millisecond).String(),
+			errText,
+		)
+	}
+
+	table.Render()
+}
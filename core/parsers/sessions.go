@@ -0,0 +1,314 @@
+package parsers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/i582/cfmt/cmd/cfmt"
+	"github.com/pixfid/luft/core/utils"
+	"github.com/pixfid/luft/data"
+)
+
+// Session log lines
+var (
+	// "systemd-logind[512]: New session 3 of user alice."
+	reLogindNew = regexp.MustCompile(`systemd-logind\[\d+\]: New session (\S+) of user (\S+?)\.?$`)
+	// "systemd-logind[512]: Removed session 3."
+	reLogindRemoved = regexp.MustCompile(`systemd-logind\[\d+\]: Removed session (\S+?)\.?$`)
+	// "systemd-logind[512]: New seat seat0." is logged once per boot
+	reLogindSeat = regexp.MustCompile(`systemd-logind\[\d+\]: New seat seat0\.`)
+	// "sshd[1234]: pam_unix(sshd:session): session opened for user alice(uid=1000) by (uid=0)"
+	rePamSession = regexp.MustCompile(`(\S+)\[(\d+)\]: pam_unix\(([\w-]+):session\): session (opened|closed) for user ([^\s(]+)`)
+)
+
+// pamIgnoredServices open sessions that are not a user sitting at or logging in to the host
+var pamIgnoredServices = map[string]bool{
+	"cron":         true,
+	"sudo":         true,
+	"su":           true,
+	"su-l":         true,
+	"runuser":      true,
+	"runuser-l":    true,
+	"systemd-user": true,
+}
+
+// greeterUsers are display manager accounts owning the login screen session
+var greeterUsers = map[string]bool{
+	"gdm":         true,
+	"gdm-greeter": true,
+	"lightdm":     true,
+	"sddm":        true,
+}
+
+// failedLoginWindow is how close to a connection a failed login is reported
+const failedLoginWindow = 5 * time.Minute
+
+// classifySessionLine returns the action type of logind and PAM session lines
+func classifySessionLine(logLine string) data.ActionType {
+	switch {
+	case reLogindNew.MatchString(logLine):
+		return data.Login
+	case reLogindRemoved.MatchString(logLine):
+		return data.Logout
+	case reLogindSeat.MatchString(logLine):
+		return data.Boot
+	}
+
+	if m := rePamSession.FindStringSubmatch(logLine); m != nil && !pamIgnoredServices[m[3]] {
+		if m[4] == "opened" {
+			return data.Login
+		}
+		return data.Logout
+	}
+
+	return data.Unknown
+}
+
+// CollectSessions builds user sessions from logind and PAM lines. A boot closes
+// the sessions still open on the host.
+func CollectSessions(events []data.LogEvent) []data.Session {
+	var sessions []data.Session
+
+	// Index in sessions of each open session by logind id or PAM process
+	open := make(map[nameKey]int)
+
+	for _, event := range orderLogEvents(events) {
+		host := logEventHost(event)
+
+		switch event.ActionType {
+		case data.Boot:
+			for key, idx := range open {
+				if key.host == host {
+					sessions[idx].End = event.Date
+					delete(open, key)
+				}
+			}
+
+		case data.Login:
+			var key nameKey
+			session := data.Session{Host: host, Start: event.Date}
+			if m := reLogindNew.FindStringSubmatch(event.LogLine); m != nil {
+				key = nameKey{host: host, name: "logind " + m[1]}
+				session.User = m[2]
+				session.TTY = "session " + m[1]
+			} else if m := rePamSession.FindStringSubmatch(event.LogLine); m != nil {
+				key = nameKey{host: host, name: fmt.Sprintf("pam %s[%s] %s", m[1], m[2], m[5])}
+				session.User = m[5]
+				session.TTY = m[3]
+			} else {
+				continue
+			}
+			if greeterUsers[session.User] {
+				continue
+			}
+			sessions = append(sessions, session)
+			open[key] = len(sessions) - 1
+
+		case data.Logout:
+			var key nameKey
+			if m := reLogindRemoved.FindStringSubmatch(event.LogLine); m != nil {
+				key = nameKey{host: host, name: "logind " + m[1]}
+			} else if m := rePamSession.FindStringSubmatch(event.LogLine); m != nil {
+				key = nameKey{host: host, name: fmt.Sprintf("pam %s[%s] %s", m[1], m[2], m[5])}
+			} else {
+				continue
+			}
+			if idx, ok := open[key]; ok {
+				sessions[idx].End = event.Date
+				delete(open, key)
+			}
+		}
+	}
+
+	return sessions
+}
+
+// utmp record layout of Linux on 64-bit and 32-bit architectures (struct utmp, 384 bytes)
+const (
+	utmpRecordSize = 384
+
+	utmpRunLevel    = 1
+	utmpBootTime    = 2
+	utmpUserProcess = 7
+	utmpDeadProcess = 8
+)
+
+// utmpRecord is the part of a utmp record luft needs
+type utmpRecord struct {
+	kind int16
+	line string
+	user string
+	host string
+	time time.Time
+}
+
+func cString(b []byte) string {
+	if idx := bytes.IndexByte(b, 0); idx >= 0 {
+		b = b[:idx]
+	}
+	return string(b)
+}
+
+// readUtmp reads binary utmp records (wtmp, btmp) from r
+func readUtmp(r io.Reader) ([]utmpRecord, error) {
+	var records []utmpRecord
+	buf := make([]byte, utmpRecordSize)
+
+	for {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			if errors.Is(err, io.EOF) {
+				return records, nil
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return records, fmt.Errorf("truncated utmp record after %d records", len(records))
+			}
+			return records, err
+		}
+
+		records = append(records, utmpRecord{
+			kind: int16(binary.LittleEndian.Uint16(buf[0:2])),
+			line: cString(buf[8:40]),
+			user: cString(buf[44:76]),
+			host: cString(buf[76:332]),
+			time: time.Unix(int64(int32(binary.LittleEndian.Uint32(buf[340:344]))),
+				int64(int32(binary.LittleEndian.Uint32(buf[344:348])))*int64(time.Microsecond)),
+		})
+	}
+}
+
+// ReadLoginRecords reads sessions from wtmp and failed logins from btmp records in r,
+// name is the file name the kind of records is taken from
+func ReadLoginRecords(r io.Reader, name string) ([]data.Session, error) {
+	records, err := readUtmp(r)
+
+	var sessions []data.Session
+	if strings.HasPrefix(filepath.Base(name), "btmp") {
+		for _, record := range records {
+			if record.user == "" {
+				continue
+			}
+			sessions = append(sessions, data.Session{
+				User:   record.user,
+				TTY:    record.line,
+				From:   record.host,
+				Start:  record.time,
+				End:    record.time,
+				Failed: true,
+			})
+		}
+		return sessions, err
+	}
+
+	// Index in sessions of the open session on each tty
+	open := make(map[string]int)
+	for _, record := range records {
+		switch {
+		case record.kind == utmpUserProcess && record.user != "":
+			sessions = append(sessions, data.Session{
+				User:  record.user,
+				TTY:   record.line,
+				From:  record.host,
+				Start: record.time,
+			})
+			open[record.line] = len(sessions) - 1
+
+		case record.kind == utmpDeadProcess:
+			if idx, ok := open[record.line]; ok {
+				sessions[idx].End = record.time
+				delete(open, record.line)
+			}
+
+		case record.kind == utmpBootTime, record.kind == utmpRunLevel && record.user == "shutdown":
+			for line, idx := range open {
+				sessions[idx].End = record.time
+				delete(open, line)
+			}
+		}
+	}
+
+	return sessions, err
+}
+
+// IsLoginRecordFile reports whether path is a wtmp or btmp file, rotated ones included
+func IsLoginRecordFile(path string) bool {
+	name := filepath.Base(path)
+	return strings.HasPrefix(name, "wtmp") || strings.HasPrefix(name, "btmp")
+}
+
+// readLoginFiles reads the sessions of wtmp and btmp files opened with open, so
+// local and SFTP files are handled alike
+func readLoginFiles(files []string, open func(path string) (io.ReadCloser, error)) []data.Session {
+	var sessions []data.Session
+
+	for _, path := range files {
+		// Rotated wtmp files may be compressed like the logs
+		reader, err := utils.OpenDecompressed(path, open)
+		if err != nil {
+			_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: failed to open file %s: %s}}::yellow", time.Now().Format(time.Stamp), path, err.Error()))
+			continue
+		}
+
+		records, err := ReadLoginRecords(reader, path)
+		reader.Close()
+		if err != nil {
+			_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: %s: %s}}::yellow", time.Now().Format(time.Stamp), path, err.Error()))
+		}
+		sessions = append(sessions, records...)
+	}
+
+	return sessions
+}
+
+// AnnotateUsers records on each device the users with a session active at its
+// connection time, and the users with failed logins within failedLoginWindow of it
+func AnnotateUsers(events []data.Event, sessions []data.Session) {
+	for i := range events {
+		connected := events[i].ConnectedTime
+		if connected.IsZero() {
+			continue
+		}
+
+		users := make(map[string]bool)
+		failed := make(map[string]bool)
+		for _, session := range sessions {
+			if session.Host != "" && events[i].Host != "" && !strings.EqualFold(session.Host, events[i].Host) {
+				continue
+			}
+
+			if session.Failed {
+				gap := session.Start.Sub(connected)
+				if gap >= -failedLoginWindow && gap <= failedLoginWindow {
+					failed[session.User] = true
+				}
+				continue
+			}
+
+			if !session.Start.After(connected) && (session.End.IsZero() || !session.End.Before(connected)) {
+				users[session.User] = true
+			}
+		}
+
+		events[i].Users = sortedKeys(users)
+		events[i].FailedLogins = sortedKeys(failed)
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	if len(set) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
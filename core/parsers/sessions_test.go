@@ -0,0 +1,138 @@
+package parsers
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pixfid/luft/data"
+)
+
+// boot is the first record of testdata/wtmp, the other records are offsets from it
+var boot = time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestReadUtmp(t *testing.T) {
+	wtmp := readFixture(t, "wtmp")
+
+	tests := []struct {
+		name    string
+		input   []byte
+		records []utmpRecord
+		err     string
+	}{
+		{name: "empty", input: nil},
+		{
+			name:  "records",
+			input: wtmp,
+			records: []utmpRecord{
+				{kind: utmpBootTime, line: "~", user: "reboot", host: "6.1.0", time: boot},
+				{kind: utmpUserProcess, line: "pts/0", user: "alice", host: "10.0.0.2", time: boot.Add(time.Minute + 250*time.Millisecond)},
+				{kind: utmpUserProcess, line: "tty1", user: "bob", time: boot.Add(2 * time.Minute)},
+				{kind: utmpDeadProcess, line: "pts/0", time: boot.Add(10 * time.Minute)},
+				{kind: utmpRunLevel, line: "~", user: "shutdown", host: "6.1.0", time: boot.Add(time.Hour)},
+			},
+		},
+		{
+			name:  "truncated record",
+			input: wtmp[:2*utmpRecordSize+100],
+			records: []utmpRecord{
+				{kind: utmpBootTime, line: "~", user: "reboot", host: "6.1.0", time: boot},
+				{kind: utmpUserProcess, line: "pts/0", user: "alice", host: "10.0.0.2", time: boot.Add(time.Minute + 250*time.Millisecond)},
+			},
+			err: "truncated utmp record after 2 records",
+		},
+		{name: "shorter than a record", input: wtmp[:100], err: "truncated utmp record after 0 records"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := readUtmp(bytes.NewReader(tt.input))
+			if tt.err == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.err)
+			}
+
+			if len(records) != len(tt.records) {
+				t.Fatalf("got %d records, want %d: %+v", len(records), len(tt.records), records)
+			}
+			for i := range records {
+				got, want := records[i], tt.records[i]
+				if got.kind != want.kind || got.line != want.line || got.user != want.user ||
+					got.host != want.host || !got.time.Equal(want.time) {
+					t.Errorf("record %d = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadLoginRecords(t *testing.T) {
+	tests := []struct {
+		name     string
+		fixture  string
+		path     string
+		sessions []data.Session
+	}{
+		{
+			name:    "wtmp",
+			fixture: "wtmp",
+			path:    "/var/log/wtmp",
+			sessions: []data.Session{
+				// Closed by its dead process record
+				{User: "alice", TTY: "pts/0", From: "10.0.0.2", Start: boot.Add(time.Minute + 250*time.Millisecond), End: boot.Add(10 * time.Minute)},
+				// Closed by the shutdown
+				{User: "bob", TTY: "tty1", Start: boot.Add(2 * time.Minute), End: boot.Add(time.Hour)},
+			},
+		},
+		{
+			// Records without a user are skipped
+			name:    "btmp",
+			fixture: "btmp",
+			path:    "/var/log/btmp",
+			sessions: []data.Session{
+				{User: "root", TTY: "ssh:notty", From: "203.0.113.9", Start: boot.Add(30 * time.Second), End: boot.Add(30 * time.Second), Failed: true},
+			},
+		},
+		{
+			// The kind of records is taken from the file name
+			name:    "rotated btmp",
+			fixture: "btmp",
+			path:    "/var/log/btmp.1",
+			sessions: []data.Session{
+				{User: "root", TTY: "ssh:notty", From: "203.0.113.9", Start: boot.Add(30 * time.Second), End: boot.Add(30 * time.Second), Failed: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions, err := ReadLoginRecords(bytes.NewReader(readFixture(t, tt.fixture)), tt.path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for i := range sessions {
+				sessions[i].Start = sessions[i].Start.UTC()
+				sessions[i].End = sessions[i].End.UTC()
+			}
+			if !reflect.DeepEqual(sessions, tt.sessions) {
+				t.Errorf("sessions = %+v, want %+v", sessions, tt.sessions)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+// compressionFormat is a compression format of rotated logs, recognised by the
+// magic bytes its streams start with
+type compressionFormat struct {
+	name   string
+	magic  []byte
+	reader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var compressionFormats = []compressionFormat{
+	{"gzip", []byte{0x1f, 0x8b}, func(r io.Reader) (io.ReadCloser, error) {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return gr, nil
+	}},
+	{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, func(r io.Reader) (io.ReadCloser, error) {
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	}},
+	{"bzip2", []byte("BZh"), func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	}},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}, func(r io.Reader) (io.ReadCloser, error) {
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}},
+	{"lz4", []byte{0x04, 0x22, 0x4d, 0x18}, func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(lz4.NewReader(r)), nil
+	}},
+}
+
+// maxMagicLength is the length of the longest magic number
+const maxMagicLength = 6
+
+// DetectCompression returns the compression format head starts with: gzip, xz,
+// bzip2, zstd or lz4, empty for uncompressed data
+func DetectCompression(head []byte) string {
+	for _, format := range compressionFormats {
+		if bytes.HasPrefix(head, format.magic) {
+			return format.name
+		}
+	}
+	return ""
+}
+
+// Decompress returns a reader of the decompressed content of r, chosen by its
+// magic bytes rather than the file extension, and the compression format. An
+// uncompressed r is read as is and the format is empty. Closing the reader
+// releases the decompressor but not r.
+func Decompress(r io.Reader) (io.ReadCloser, string, error) {
+	buffered := bufio.NewReader(r)
+	head, err := buffered.Peek(maxMagicLength)
+	if err != nil && err != io.EOF {
+		return nil, "", err
+	}
+
+	for _, format := range compressionFormats {
+		if bytes.HasPrefix(head, format.magic) {
+			reader, err := format.reader(buffered)
+			if err != nil {
+				return nil, format.name, fmt.Errorf("failed to create %s reader: %w", format.name, err)
+			}
+			return reader, format.name, nil
+		}
+	}
+
+	return io.NopCloser(buffered), "", nil
+}
+
+// decompressedFile closes the decompressor, then the file it reads
+type decompressedFile struct {
+	io.ReadCloser
+	file io.Closer
+}
+
+func (d decompressedFile) Close() error {
+	err := d.ReadCloser.Close()
+	if closeErr := d.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// OpenDecompressed opens path with open, a local or SFTP open, and returns a
+// reader of its decompressed content. Closing the reader closes the file.
+func OpenDecompressed(path string, open func(path string) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	file, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, _, err := Decompress(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return decompressedFile{ReadCloser: reader, file: file}, nil
+}
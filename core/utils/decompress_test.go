@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDetectCompression(t *testing.T) {
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{"gzip", []byte{0x1f, 0x8b, 0x08, 0x00}, "gzip"},
+		{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00}, "xz"},
+		{"bzip2", []byte("BZh91AY&SY"), "bzip2"},
+		{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd, 0x04}, "zstd"},
+		{"lz4", []byte{0x04, 0x22, 0x4d, 0x18, 0x64}, "lz4"},
+		{"plain text", []byte("Jan  1 10:00:01 host kernel: usb 1-2"), ""},
+		{"xz magic cut short", []byte{0xfd, '7', 'z'}, ""},
+		{"bzip2 magic cut short", []byte("BZ"), ""},
+		{"empty", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectCompression(tt.head); got != tt.want {
+				t.Errorf("DetectCompression(% x) = %q, want %q", tt.head, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	plain, err := os.ReadFile(filepath.Join("testdata", "kern.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		file   string
+		input  []byte
+		format string
+		want   []byte
+		err    string
+	}{
+		{name: "plain", file: "kern.log", want: plain},
+		{name: "gzip", file: "kern.log.gz", format: "gzip", want: plain},
+		{name: "xz", file: "kern.log.xz", format: "xz", want: plain},
+		{name: "bzip2", file: "kern.log.bz2", format: "bzip2", want: plain},
+		{name: "zstd", file: "kern.log.zst", format: "zstd", want: plain},
+		{name: "lz4", file: "kern.log.lz4", format: "lz4", want: plain},
+		// Shorter than the longest magic number
+		{name: "short plain", input: []byte("ok"), want: []byte("ok")},
+		{name: "empty", input: []byte{}, want: []byte{}},
+		{name: "gzip header cut short", input: []byte{0x1f, 0x8b, 0x08}, format: "gzip", err: "failed to create gzip reader"},
+		{name: "xz header cut short", input: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, format: "xz", err: "failed to create xz reader"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := tt.input
+			if tt.file != "" {
+				if input, err = os.ReadFile(filepath.Join("testdata", tt.file)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			reader, format, err := Decompress(bytes.NewReader(input))
+			if format != tt.format {
+				t.Errorf("format = %q, want %q", format, tt.format)
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer reader.Close()
+
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestOpenDecompressed checks that closing the reader of a rotated log closes
+// the file it reads
+func TestOpenDecompressed(t *testing.T) {
+	plain, err := os.ReadFile(filepath.Join("testdata", "kern.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var opened *closeRecorder
+	open := func(path string) (io.ReadCloser, error) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		opened = &closeRecorder{ReadCloser: file}
+		return opened, nil
+	}
+
+	reader, err := OpenDecompressed(filepath.Join("testdata", "kern.log.zst"), open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Errorf("content = %q, want %q", got, plain)
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !opened.closed {
+		t.Error("file not closed with the reader")
+	}
+
+	if _, err := OpenDecompressed(filepath.Join("testdata", "missing.gz"), open); !os.IsNotExist(err) {
+		t.Errorf("error = %v, want a not exist error", err)
+	}
+}
+
+type closeRecorder struct {
+	io.ReadCloser
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return c.ReadCloser.Close()
+}
@@ -3,14 +3,39 @@ package utils
 import (
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"os/user"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/i582/cfmt/cmd/cfmt"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/term"
 )
 
+// ErrSSHKeyEncrypted is returned when an encrypted key is loaded without passphrase
+var ErrSSHKeyEncrypted = errors.New("SSH key is encrypted but no passphrase provided")
+
+var (
+	// Keys are loaded once, so hosts scanned together ask for a passphrase once
+	sshSigners   = make(map[string]ssh.Signer)
+	sshSignersMu sync.Mutex
+
+	sshAgent     agent.Agent
+	sshAgentOnce sync.Once
+)
+
+// defaultIdentityFiles are the keys ssh tries when none is configured
+var defaultIdentityFiles = []string{"~/.ssh/id_ed25519", "~/.ssh/id_ecdsa", "~/.ssh/id_rsa"}
+
 // GetHostKeyCallback returns appropriate SSH host key callback based on security settings
 // If insecure is true, returns InsecureIgnoreHostKey (NOT RECOMMENDED)
 // Otherwise, attempts to use known_hosts file for verification
@@ -49,6 +74,8 @@ func LoadSSHPrivateKey(keyPath string, passphrase []byte) (ssh.Signer, error) {
 	if err == nil {
 		return signer, nil
 	}
+	var missingErr *ssh.PassphraseMissingError
+	encrypted := errors.As(err, &missingErr)
 
 	// If failed and passphrase provided, try with passphrase
 	if len(passphrase) > 0 {
@@ -61,24 +88,200 @@ func LoadSSHPrivateKey(keyPath string, passphrase []byte) (ssh.Signer, error) {
 
 	// Check if key is encrypted but no passphrase provided
 	block, _ := pem.Decode(keyData)
-	if block != nil && x509.IsEncryptedPEMBlock(block) {
-		return nil, fmt.Errorf("SSH key is encrypted but no passphrase provided")
+	if encrypted || (block != nil && x509.IsEncryptedPEMBlock(block)) {
+		return nil, ErrSSHKeyEncrypted
 	}
 
 	return nil, fmt.Errorf("failed to parse SSH private key: %w", err)
 }
 
-// GetSSHAuthMethods returns SSH authentication methods based on provided credentials
-func GetSSHAuthMethods(keyPath string, password string) ([]ssh.AuthMethod, error) {
+// loadSSHSigner loads a private key, asking for its passphrase when it is
+// encrypted and a terminal is available
+func loadSSHSigner(keyPath string) (ssh.Signer, error) {
+	sshSignersMu.Lock()
+	defer sshSignersMu.Unlock()
+
+	if signer, ok := sshSigners[keyPath]; ok {
+		return signer, nil
+	}
+
+	signer, err := LoadSSHPrivateKey(keyPath, nil)
+	if errors.Is(err, ErrSSHKeyEncrypted) {
+		passphrase, promptErr := readPassphrase(keyPath)
+		if promptErr != nil {
+			return nil, fmt.Errorf("%w: %v", err, promptErr)
+		}
+		signer, err = LoadSSHPrivateKey(keyPath, passphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sshSigners[keyPath] = signer
+	return signer, nil
+}
+
+// readPassphrase asks for the passphrase of a key on the terminal
+func readPassphrase(keyPath string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("no terminal to ask for the passphrase, add the key to ssh-agent")
+	}
+
+	fmt.Fprintf(os.Stderr, "Enter passphrase for key '%s': ", keyPath)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return passphrase, err
+}
+
+// agentClient returns the ssh-agent listening on SSH_AUTH_SOCK, or nil
+func agentClient() agent.Agent {
+	sshAgentOnce.Do(func() {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return
+		}
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: failed to connect to ssh-agent: %s}}::yellow", time.Now().Format(time.Stamp), err.Error()))
+			return
+		}
+		sshAgent = agent.NewClient(conn)
+	})
+	return sshAgent
+}
+
+// publicKeyOf returns the public key of an encrypted key file: OpenSSH keys store
+// it in the clear, PEM keys need the .pub file next to them. It returns nil when
+// neither is readable.
+func publicKeyOf(keyPath string) ssh.PublicKey {
+	if keyData, err := os.ReadFile(keyPath); err == nil {
+		var missingErr *ssh.PassphraseMissingError
+		if _, err := ssh.ParsePrivateKey(keyData); errors.As(err, &missingErr) && missingErr.PublicKey != nil {
+			return missingErr.PublicKey
+		}
+	}
+
+	pubData, err := os.ReadFile(keyPath + ".pub")
+	if err != nil {
+		return nil
+	}
+	public, _, _, _, err := ssh.ParseAuthorizedKey(pubData)
+	if err != nil {
+		return nil
+	}
+	return public
+}
+
+// lazySigner is an encrypted key whose private half is decrypted, asking for the
+// passphrase, only once a server accepts its public key
+type lazySigner struct {
+	path   string
+	public ssh.PublicKey
+}
+
+func (s lazySigner) PublicKey() ssh.PublicKey {
+	return s.public
+}
+
+func (s lazySigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	signer, err := loadSSHSigner(s.path)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Sign(rand, data)
+}
+
+func (s lazySigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	signer, err := loadSSHSigner(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if algorithmSigner, ok := signer.(ssh.AlgorithmSigner); ok {
+		return algorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+	}
+	return signer.Sign(rand, data)
+}
+
+// keySigner returns the signer of a key file. An encrypted key is only decrypted
+// when a server accepts it, so no passphrase is asked for a key the agent holds
+// or the server refuses, unless its public key can't be read.
+func keySigner(keyPath string) (ssh.Signer, error) {
+	signer, err := LoadSSHPrivateKey(keyPath, nil)
+	if !errors.Is(err, ErrSSHKeyEncrypted) {
+		return signer, err
+	}
+	if public := publicKeyOf(keyPath); public != nil {
+		return lazySigner{path: keyPath, public: public}, nil
+	}
+	return loadSSHSigner(keyPath)
+}
+
+// offeredSigners returns the keys loaded in the agent followed by the key files
+// the agent doesn't hold
+func offeredSigners(sshAgent agent.Agent, keys []ssh.Signer) []ssh.Signer {
+	if sshAgent == nil {
+		return keys
+	}
+	agentSigners, err := sshAgent.Signers()
+	if err != nil {
+		return keys
+	}
+
+	held := make(map[string]bool, len(agentSigners))
+	for _, signer := range agentSigners {
+		held[string(signer.PublicKey().Marshal())] = true
+	}
+	signers := append([]ssh.Signer{}, agentSigners...)
+	for _, signer := range keys {
+		if !held[string(signer.PublicKey().Marshal())] {
+			signers = append(signers, signer)
+		}
+	}
+	return signers
+}
+
+// GetSSHAuthMethods returns SSH authentication methods based on provided credentials.
+// Keys are tried in order: the ssh-agent keys, keyPath, then the identity files (or
+// the default keys of ssh when there are none); the password comes last.
+func GetSSHAuthMethods(keyPath string, identityFiles []string, password string) ([]ssh.AuthMethod, error) {
 	var authMethods []ssh.AuthMethod
+	var signers []ssh.Signer
 
 	// Prefer key-based authentication
 	if keyPath != "" {
-		signer, err := LoadSSHPrivateKey(keyPath, nil)
+		signer, err := keySigner(keyPath)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load SSH key: %w", err)
 		}
-		authMethods = append(authMethods, ssh.PublicKeys(signer))
+		signers = append(signers, signer)
+	}
+
+	// Identity files are optional, as in ssh the missing ones are skipped
+	if keyPath == "" && len(identityFiles) == 0 {
+		identityFiles = defaultIdentityFiles
+	}
+	for _, identityFile := range identityFiles {
+		identityFile = expandHome(identityFile)
+		if identityFile == keyPath {
+			continue
+		}
+		if _, err := os.Stat(identityFile); err != nil {
+			continue
+		}
+		signer, err := keySigner(identityFile)
+		if err != nil {
+			_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: skipping SSH key %s: %s}}::yellow", time.Now().Format(time.Stamp), identityFile, err.Error()))
+			continue
+		}
+		signers = append(signers, signer)
+	}
+
+	sshAgent := agentClient()
+	if len(signers) > 0 || sshAgent != nil {
+		authMethods = append(authMethods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			return offeredSigners(sshAgent, signers), nil
+		}))
 	}
 
 	// Add password authentication if provided (as fallback or standalone)
@@ -87,8 +290,194 @@ func GetSSHAuthMethods(keyPath string, password string) ([]ssh.AuthMethod, error
 	}
 
 	if len(authMethods) == 0 {
-		return nil, fmt.Errorf("no authentication method provided (need SSH key or password)")
+		return nil, fmt.Errorf("no authentication method provided (need SSH key, ssh-agent or password)")
 	}
 
 	return authMethods, nil
 }
+
+// SSHEndpoint is an SSH server and the credentials to log in to it
+type SSHEndpoint struct {
+	Host          string // address or ~/.ssh/config Host alias
+	Port          string
+	User          string
+	KeyPath       string
+	IdentityFiles []string // keys from ~/.ssh/config, tried after KeyPath
+	Password      string
+	ProxyJump     string        // comma separated [user@]host[:port] jump hosts
+	JumpHosts     []SSHEndpoint // jump hosts with their own credentials, replacing ProxyJump
+	Timeout       time.Duration
+	Insecure      bool // skip host key verification (NOT RECOMMENDED)
+}
+
+// Address returns the host:port of the endpoint
+func (e SSHEndpoint) Address() string {
+	return net.JoinHostPort(e.Host, e.Port)
+}
+
+// ResolveSSHEndpoint completes an endpoint from ~/.ssh/config: a Host alias is
+// replaced by its HostName, and the user, port (when unset or 22), identity
+// files and jump hosts come from the configuration. The local user name is the
+// last resort for the user, as in ssh.
+func ResolveSSHEndpoint(e SSHEndpoint) SSHEndpoint {
+	cfg := UserSSHConfig().Lookup(e.Host)
+
+	if cfg.HostName != "" {
+		e.Host = cfg.HostName
+	}
+	if e.User == "" {
+		e.User = cfg.User
+	}
+	if e.User == "" {
+		if u, err := user.Current(); err == nil {
+			e.User = u.Username
+		}
+	}
+	if (e.Port == "" || e.Port == "22") && cfg.Port != "" {
+		e.Port = cfg.Port
+	}
+	if e.Port == "" {
+		e.Port = "22"
+	}
+	e.IdentityFiles = append(e.IdentityFiles, cfg.IdentityFiles...)
+	if e.ProxyJump == "" {
+		e.ProxyJump = cfg.ProxyJump
+	}
+
+	return e
+}
+
+// ParseJumpHost parses a [user@]host[:port] jump host
+func ParseJumpHost(spec string) SSHEndpoint {
+	var hop SSHEndpoint
+	if at := strings.LastIndex(spec, "@"); at >= 0 {
+		hop.User, spec = spec[:at], spec[at+1:]
+	}
+	if host, port, err := net.SplitHostPort(spec); err == nil {
+		hop.Host, hop.Port = host, port
+	} else {
+		hop.Host = spec
+	}
+	return hop
+}
+
+// clientConfig returns the SSH client configuration logging in to the endpoint
+func (e SSHEndpoint) clientConfig() (*ssh.ClientConfig, error) {
+	authMethods, err := GetSSHAuthMethods(e.KeyPath, e.IdentityFiles, e.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup authentication: %w", err)
+	}
+
+	hostKeyCallback, err := GetHostKeyCallback(e.Insecure)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup host key verification (hint: use --insecure-ssh to skip verification, NOT RECOMMENDED): %w", err)
+	}
+
+	return &ssh.ClientConfig{
+		User:            e.User,
+		Auth:            authMethods,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         e.Timeout,
+	}, nil
+}
+
+// jumpHops returns the jump hosts of a resolved endpoint, resolved from
+// ~/.ssh/config. A hop without a key of its own offers the key of the endpoint,
+// the password of the endpoint is never sent to a hop.
+func (e SSHEndpoint) jumpHops() []SSHEndpoint {
+	var hops []SSHEndpoint
+	if len(e.JumpHosts) > 0 {
+		hops = append(hops, e.JumpHosts...)
+	} else if e.ProxyJump != "" {
+		for _, spec := range strings.Split(e.ProxyJump, ",") {
+			hop := ParseJumpHost(strings.TrimSpace(spec))
+			hop.Insecure = e.Insecure
+			hops = append(hops, hop)
+		}
+	}
+
+	for i, hop := range hops {
+		hop = ResolveSSHEndpoint(hop)
+		if hop.KeyPath == "" {
+			hop.KeyPath = e.KeyPath
+		}
+		hop.Timeout = e.Timeout
+		hop.ProxyJump, hop.JumpHosts = "", nil
+		hops[i] = hop
+	}
+	return hops
+}
+
+// JumpRoute describes the jump hosts of a resolved endpoint, empty without any
+func (e SSHEndpoint) JumpRoute() string {
+	var route []string
+	for _, hop := range e.jumpHops() {
+		route = append(route, fmt.Sprintf("%s@%s", hop.User, hop.Address()))
+	}
+	return strings.Join(route, " -> ")
+}
+
+// DialSSH connects to a resolved endpoint, through its jump hosts if it has
+// any. Each hop is logged in to with its own credentials and its host key is
+// verified on its own.
+func DialSSH(e SSHEndpoint) (*ssh.Client, error) {
+	hops := e.jumpHops()
+	e.ProxyJump, e.JumpHosts = "", nil
+
+	var clients []*ssh.Client
+	closeAll := func() {
+		for i := len(clients) - 1; i >= 0; i-- {
+			clients[i].Close()
+		}
+	}
+
+	for i, hop := range append(hops, e) {
+		name := hop.Address()
+		if i < len(hops) {
+			name = "jump host " + name
+		}
+
+		config, err := hop.clientConfig()
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		var client *ssh.Client
+		if len(clients) == 0 {
+			client, err = ssh.Dial("tcp", hop.Address(), config)
+		} else {
+			client, err = dialThrough(clients[len(clients)-1], hop.Address(), config)
+		}
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("failed to connect to %s: %w", name, err)
+		}
+		clients = append(clients, client)
+	}
+
+	// Closing the endpoint connection closes the tunnels it went through
+	target := clients[len(clients)-1]
+	if len(clients) > 1 {
+		go func() {
+			_ = target.Wait()
+			closeAll()
+		}()
+	}
+	return target, nil
+}
+
+// dialThrough opens an SSH connection to addr tunnelled through an established client
+func dialThrough(jump *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := jump.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(clientConn, chans, reqs), nil
+}
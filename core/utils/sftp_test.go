@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func newTestKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func testSigner(t *testing.T, key ed25519.PrivateKey) ssh.Signer {
+	t.Helper()
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// TestKeySignerEncrypted checks that an encrypted key is loaded without asking
+// for its passphrase, which would fail without a terminal
+func TestKeySignerEncrypted(t *testing.T) {
+	key := newTestKey(t)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := keySigner(path)
+	if err != nil {
+		t.Fatalf("keySigner: %v", err)
+	}
+	if _, ok := signer.(lazySigner); !ok {
+		t.Errorf("signer = %T, want the key decrypted when used", signer)
+	}
+	if got, want := signer.PublicKey().Marshal(), testSigner(t, key).PublicKey().Marshal(); string(got) != string(want) {
+		t.Error("public key differs from the key file")
+	}
+}
+
+func TestOfferedSigners(t *testing.T) {
+	held, other := newTestKey(t), newTestKey(t)
+
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: held}); err != nil {
+		t.Fatal(err)
+	}
+	heldFile, otherFile := testSigner(t, held), testSigner(t, other)
+
+	tests := []struct {
+		name  string
+		agent agent.Agent
+		keys  []ssh.Signer
+		want  []ssh.PublicKey
+	}{
+		{
+			name:  "agent keys first, key files the agent holds left out",
+			agent: keyring,
+			keys:  []ssh.Signer{otherFile, heldFile},
+			want:  []ssh.PublicKey{heldFile.PublicKey(), otherFile.PublicKey()},
+		},
+		{
+			name: "no agent",
+			keys: []ssh.Signer{heldFile, otherFile},
+			want: []ssh.PublicKey{heldFile.PublicKey(), otherFile.PublicKey()},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := offeredSigners(tt.agent, tt.keys)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d signers, want %d", len(got), len(tt.want))
+			}
+			for i, signer := range got {
+				if string(signer.PublicKey().Marshal()) != string(tt.want[i].Marshal()) {
+					t.Errorf("signer %d is %s, want %s", i, ssh.FingerprintSHA256(signer.PublicKey()), ssh.FingerprintSHA256(tt.want[i]))
+				}
+			}
+		})
+	}
+}
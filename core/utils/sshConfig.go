@@ -0,0 +1,263 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/i582/cfmt/cmd/cfmt"
+)
+
+// SSHHostConfig is what the OpenSSH client configuration says about a host
+type SSHHostConfig struct {
+	HostName      string
+	User          string
+	Port          string
+	IdentityFiles []string
+	ProxyJump     string
+}
+
+// sshConfigBlock is a Host block of an OpenSSH client configuration. Options
+// before the first Host line go to a block matching every host.
+type sshConfigBlock struct {
+	patterns []string
+	options  [][2]string
+}
+
+// SSHConfig is a parsed OpenSSH client configuration, see ssh_config(5)
+type SSHConfig struct {
+	blocks []*sshConfigBlock
+}
+
+var (
+	userSSHConfig     *SSHConfig
+	userSSHConfigOnce sync.Once
+)
+
+// UserSSHConfig returns the configuration of ~/.ssh/config, empty when the file
+// is missing or unreadable
+func UserSSHConfig() *SSHConfig {
+	userSSHConfigOnce.Do(func() {
+		userSSHConfig = &SSHConfig{}
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return
+		}
+		cfg, err := LoadSSHConfig(filepath.Join(home, ".ssh", "config"))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: ignoring ~/.ssh/config: %s}}::yellow", time.Now().Format(time.Stamp), err.Error()))
+			}
+			return
+		}
+		userSSHConfig = cfg
+	})
+	return userSSHConfig
+}
+
+// LoadSSHConfig parses an OpenSSH client configuration file and the files it includes
+func LoadSSHConfig(path string) (*SSHConfig, error) {
+	cfg := &SSHConfig{}
+	current := &sshConfigBlock{patterns: []string{"*"}}
+	cfg.blocks = append(cfg.blocks, current)
+
+	if err := cfg.parseFile(path, &current, 0); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parseFile reads the lines of path into cfg. Included files continue the
+// block they are included from, as ssh does.
+func (c *SSHConfig) parseFile(path string, current **sshConfigBlock, depth int) error {
+	if depth > 16 {
+		return fmt.Errorf("%s: too many nested includes", path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		keyword, args := splitSSHConfigLine(line)
+		if len(args) == 0 {
+			return fmt.Errorf("%s:%d: %s needs a value", path, lineNumber, keyword)
+		}
+
+		switch strings.ToLower(keyword) {
+		case "host":
+			*current = &sshConfigBlock{patterns: args}
+			c.blocks = append(c.blocks, *current)
+		case "match":
+			// Match conditions are not evaluated, their options never apply
+			*current = &sshConfigBlock{}
+			c.blocks = append(c.blocks, *current)
+		case "include":
+			for _, pattern := range args {
+				pattern = expandHome(pattern)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(filepath.Dir(path), pattern)
+				}
+				matches, err := filepath.Glob(pattern)
+				if err != nil {
+					return fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+				}
+				for _, match := range matches {
+					if err := c.parseFile(match, current, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+		default:
+			(*current).options = append((*current).options, [2]string{strings.ToLower(keyword), strings.Join(args, " ")})
+		}
+	}
+
+	return scanner.Err()
+}
+
+// splitSSHConfigLine splits a line into its keyword and arguments. The keyword
+// may be followed by spaces or '=', arguments may be double quoted.
+func splitSSHConfigLine(line string) (string, []string) {
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return line, nil
+	}
+	keyword := line[:end]
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+
+	var args []string
+	for rest != "" {
+		var arg string
+		if rest[0] == '"' {
+			closing := strings.IndexByte(rest[1:], '"')
+			if closing < 0 {
+				arg, rest = rest[1:], ""
+			} else {
+				arg, rest = rest[1:closing+1], rest[closing+2:]
+			}
+		} else if i := strings.IndexAny(rest, " \t"); i >= 0 {
+			arg, rest = rest[:i], rest[i:]
+		} else {
+			arg, rest = rest, ""
+		}
+		args = append(args, arg)
+		rest = strings.TrimLeft(rest, " \t")
+	}
+
+	return keyword, args
+}
+
+// matches reports whether a Host block applies to alias: one of its patterns
+// matches and none of its negated patterns does
+func (b *sshConfigBlock) matches(alias string) bool {
+	matched := false
+	for _, pattern := range b.patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		re, err := globPattern(strings.TrimPrefix(pattern, "!"), true)
+		if err != nil || !re.MatchString(alias) {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// Lookup returns the settings for alias. As in ssh, the first value found for
+// an option wins, except IdentityFile which accumulates.
+func (c *SSHConfig) Lookup(alias string) SSHHostConfig {
+	var host SSHHostConfig
+
+	for _, block := range c.blocks {
+		if !block.matches(alias) {
+			continue
+		}
+		for _, option := range block.options {
+			value := option[1]
+			switch option[0] {
+			case "hostname":
+				if host.HostName == "" {
+					host.HostName = value
+				}
+			case "user":
+				if host.User == "" {
+					host.User = value
+				}
+			case "port":
+				if host.Port == "" {
+					host.Port = value
+				}
+			case "identityfile":
+				host.IdentityFiles = append(host.IdentityFiles, value)
+			case "proxyjump":
+				if host.ProxyJump == "" {
+					host.ProxyJump = value
+				}
+			}
+		}
+	}
+
+	if host.HostName != "" {
+		host.HostName = expandSSHTokens(host.HostName, alias, host.User)
+	}
+	// In other options %h is the resolved host name
+	hostName := alias
+	if host.HostName != "" {
+		hostName = host.HostName
+	}
+	for i, identity := range host.IdentityFiles {
+		host.IdentityFiles[i] = expandHome(expandSSHTokens(identity, hostName, host.User))
+	}
+	if strings.EqualFold(host.ProxyJump, "none") {
+		host.ProxyJump = ""
+	}
+
+	return host
+}
+
+// expandSSHTokens expands the %h, %r, %u, %d and %% tokens of ssh_config(5)
+func expandSSHTokens(value string, host string, remoteUser string) string {
+	if !strings.Contains(value, "%") {
+		return value
+	}
+
+	localUser, home := "", ""
+	if u, err := user.Current(); err == nil {
+		localUser, home = u.Username, u.HomeDir
+	}
+
+	return strings.NewReplacer(
+		"%%", "%",
+		"%h", host,
+		"%r", remoteUser,
+		"%u", localUser,
+		"%d", home,
+	).Replace(value)
+}
+
+// expandHome expands a leading ~ to the home directory
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
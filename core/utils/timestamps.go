@@ -0,0 +1,240 @@
+package utils
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pixfid/luft/data"
+)
+
+var (
+	// syslog.1, kern.log.2.gz, messages.3.xz
+	reRotationIndex = regexp.MustCompile(`^(.*)\.(\d+)$`)
+	// logrotate dateext: syslog-20240301, messages-20240301.gz
+	reRotationDate = regexp.MustCompile(`^(.*)-(\d{8})$`)
+
+	compressedExts = []string{".gz", ".xz", ".bz2", ".zst", ".lz4"}
+)
+
+// yearSlack tolerates events slightly after their upper bound, e.g. when the
+// analysed host ran in a different timezone than the machine running luft
+const yearSlack = 48 * time.Hour
+
+// ParseSyslogStamp parses a classic BSD syslog "Jan _2 15:04:05" timestamp.
+// The format carries no year, so the result is in year 0 until ResolveYears
+// assigns one from the file context.
+func ParseSyslogStamp(stamp string) (time.Time, error) {
+	pTime, err := time.Parse(time.Stamp, stamp)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Date(0, pTime.Month(), pTime.Day(), pTime.Hour(), pTime.Minute(), pTime.Second(), 0, time.Local), nil
+}
+
+// needsYear reports whether t came from a year-less timestamp
+func needsYear(t time.Time) bool {
+	return !t.IsZero() && t.Year() == 0
+}
+
+// ParseReferenceDate parses the --reference-date value. A plain date means the
+// end of that day, i.e. the logs were acquired some time on that date.
+func ParseReferenceDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return t.Add(24*time.Hour - time.Second), nil
+}
+
+// ParseDateBound parses a date range bound as YYYY-MM-DD or RFC 3339. A date alone
+// starts at midnight, or covers the whole day when end is set.
+func ParseDateBound(value string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t, nil
+}
+
+// rotationInfo describes where a file sits in its logrotate chain
+type rotationInfo struct {
+	base    string    // log name without rotation suffix and compression extension
+	index   int       // 0 for the active file, N for name.N
+	rotated time.Time // rotation date for dateext style names
+}
+
+func parseRotation(path string) rotationInfo {
+	name := path
+	for _, ext := range compressedExts {
+		if strings.HasSuffix(name, ext) {
+			name = strings.TrimSuffix(name, ext)
+			break
+		}
+	}
+
+	if m := reRotationIndex.FindStringSubmatch(name); m != nil {
+		index, _ := strconv.Atoi(m[2])
+		return rotationInfo{base: m[1], index: index}
+	}
+
+	if m := reRotationDate.FindStringSubmatch(name); m != nil {
+		if rotated, err := time.ParseInLocation("20060102", m[2], time.Local); err == nil {
+			return rotationInfo{base: m[1], rotated: rotated.Add(24*time.Hour - time.Second)}
+		}
+	}
+
+	return rotationInfo{base: name}
+}
+
+// newerThan reports whether r was rotated after other within the same chain
+func (r rotationInfo) newerThan(other rotationInfo) bool {
+	rActive := r.index == 0 && r.rotated.IsZero()
+	otherActive := other.index == 0 && other.rotated.IsZero()
+	switch {
+	case rActive || otherActive:
+		return rActive && !otherActive
+	case !r.rotated.IsZero() && !other.rotated.IsZero():
+		return r.rotated.After(other.rotated)
+	case !r.rotated.IsZero() || !other.rotated.IsZero():
+		// Numbered files are rotated more recently than dateext leftovers
+		return r.rotated.IsZero()
+	}
+	return r.index < other.index
+}
+
+// ResolveYears assigns years to events parsed from year-less syslog timestamps.
+//
+// Each file is resolved on its own: the time of its last event must not be later
+// than an upper bound taken from the earliest of
+//   - the reference date (offline evidence) or else the file modification time,
+//   - the rotation date of dateext style names (syslog-20240301.gz),
+//   - the first event of the next newer file in the same rotation chain.
+//
+// Inside a file, a month going backwards (Dec -> Jan) starts a new year, so files
+// spanning more than twelve months stay monotonic.
+func ResolveYears(events []data.LogEvent, modTimes map[string]time.Time, reference time.Time) {
+	groups := make(map[string][]int)
+	var sources []string
+	for i, event := range events {
+		if _, ok := groups[event.Source]; !ok {
+			sources = append(sources, event.Source)
+		}
+		groups[event.Source] = append(groups[event.Source], i)
+	}
+
+	rotations := make(map[string]rotationInfo, len(sources))
+	for _, source := range sources {
+		rotations[source] = parseRotation(source)
+	}
+
+	// Newest file of every chain first, so its first event bounds the next older one
+	sort.SliceStable(sources, func(i, j int) bool {
+		ri, rj := rotations[sources[i]], rotations[sources[j]]
+		if ri.base != rj.base {
+			return ri.base < rj.base
+		}
+		return ri.newerThan(rj)
+	})
+
+	chainBound := make(map[string]time.Time)
+	for _, source := range sources {
+		rotation := rotations[source]
+
+		var bounds []time.Time
+		if !reference.IsZero() {
+			bounds = append(bounds, reference)
+		} else if modTime, ok := modTimes[source]; ok {
+			bounds = append(bounds, modTime)
+		}
+		if !rotation.rotated.IsZero() {
+			bounds = append(bounds, rotation.rotated)
+		}
+		if bound, ok := chainBound[rotation.base]; ok {
+			bounds = append(bounds, bound)
+		}
+
+		anchor := time.Now()
+		for i, bound := range bounds {
+			if i == 0 || bound.Before(anchor) {
+				anchor = bound
+			}
+		}
+
+		resolveFileYears(events, groups[source], anchor)
+
+		for _, idx := range groups[source] {
+			if !events[idx].Date.IsZero() {
+				chainBound[rotation.base] = events[idx].Date
+				break
+			}
+		}
+	}
+}
+
+// resolveFileYears resolves the events of a single file against its upper bound
+func resolveFileYears(events []data.LogEvent, indexes []int, anchor time.Time) {
+	relYears := make(map[int]int, len(indexes))
+	relYear := 0
+	lastIdx := -1
+	var prevMonth time.Month
+
+	for _, idx := range indexes {
+		date := events[idx].Date
+		if !needsYear(date) {
+			continue
+		}
+		if lastIdx >= 0 && date.Month() < prevMonth {
+			relYear++
+		}
+		prevMonth = date.Month()
+		relYears[idx] = relYear
+		lastIdx = idx
+	}
+
+	if lastIdx < 0 {
+		return
+	}
+
+	last := events[lastIdx].Date
+	lastYear := anchor.Year()
+	if withYear(last, lastYear).After(anchor.Add(yearSlack)) {
+		lastYear--
+	}
+	offset := lastYear - relYears[lastIdx]
+
+	for idx, rel := range relYears {
+		events[idx].Date = withYear(events[idx].Date, rel+offset)
+	}
+}
+
+func withYear(t time.Time, year int) time.Time {
+	return time.Date(year, t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
+
+// LogModTimes returns modification times of local log files
+func LogModTimes(files []string) map[string]time.Time {
+	modTimes := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			modTimes[file] = info.ModTime()
+		}
+	}
+	return modTimes
+}
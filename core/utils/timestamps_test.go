@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pixfid/luft/data"
+)
+
+func TestParseRotation(t *testing.T) {
+	tests := []struct {
+		path string
+		want rotationInfo
+	}{
+		{"/var/log/syslog", rotationInfo{base: "/var/log/syslog"}},
+		{"/var/log/syslog.1", rotationInfo{base: "/var/log/syslog", index: 1}},
+		{"/var/log/kern.log.2.gz", rotationInfo{base: "/var/log/kern.log", index: 2}},
+		{"/var/log/messages.3.xz", rotationInfo{base: "/var/log/messages", index: 3}},
+		{"/var/log/syslog-20240301", rotationInfo{base: "/var/log/syslog", rotated: time.Date(2024, 3, 1, 23, 59, 59, 0, time.Local)}},
+		{"/var/log/messages-20240301.gz", rotationInfo{base: "/var/log/messages", rotated: time.Date(2024, 3, 1, 23, 59, 59, 0, time.Local)}},
+		{"/var/log/syslog.gz", rotationInfo{base: "/var/log/syslog"}},
+		// Not a date
+		{"/var/log/syslog-20241399", rotationInfo{base: "/var/log/syslog-20241399"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got := parseRotation(tt.path)
+			if got.base != tt.want.base || got.index != tt.want.index || !got.rotated.Equal(tt.want.rotated) {
+				t.Errorf("parseRotation(%q) = %+v, want %+v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveYears(t *testing.T) {
+	// stamp is a year-less syslog timestamp
+	stamp := func(month time.Month, day int) time.Time {
+		return time.Date(0, month, day, 12, 0, 0, 0, time.Local)
+	}
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 12, 0, 0, 0, time.Local)
+	}
+	type line struct {
+		source string
+		date   time.Time
+	}
+
+	tests := []struct {
+		name      string
+		lines     []line
+		modTimes  map[string]time.Time
+		reference time.Time
+		want      []time.Time
+	}{
+		{
+			name:     "last event before the modification time",
+			lines:    []line{{"syslog", stamp(time.March, 1)}, {"syslog", stamp(time.March, 2)}},
+			modTimes: map[string]time.Time{"syslog": date(2023, time.March, 5)},
+			want:     []time.Time{date(2023, time.March, 1), date(2023, time.March, 2)},
+		},
+		{
+			name:     "last event after the modification time is from the year before",
+			lines:    []line{{"syslog", stamp(time.October, 1)}},
+			modTimes: map[string]time.Time{"syslog": date(2024, time.March, 5)},
+			want:     []time.Time{date(2023, time.October, 1)},
+		},
+		{
+			name:     "month going backwards starts a new year",
+			lines:    []line{{"syslog", stamp(time.November, 30)}, {"syslog", stamp(time.December, 31)}, {"syslog", stamp(time.January, 2)}},
+			modTimes: map[string]time.Time{"syslog": date(2024, time.January, 3)},
+			want:     []time.Time{date(2023, time.November, 30), date(2023, time.December, 31), date(2024, time.January, 2)},
+		},
+		{
+			name:      "reference date wins over the modification time",
+			lines:     []line{{"syslog", stamp(time.June, 1)}},
+			modTimes:  map[string]time.Time{"syslog": date(2026, time.January, 1)},
+			reference: date(2021, time.July, 1),
+			want:      []time.Time{date(2021, time.June, 1)},
+		},
+		{
+			// Copied files all share a recent modification time: the first
+			// event of syslog bounds syslog.1, and syslog.1 bounds syslog.2.gz
+			name: "rotation chain",
+			lines: []line{
+				{"syslog.2.gz", stamp(time.November, 20)},
+				{"syslog.1", stamp(time.December, 20)},
+				{"syslog", stamp(time.February, 1)},
+			},
+			modTimes: map[string]time.Time{
+				"syslog": date(2024, time.February, 2), "syslog.1": date(2024, time.February, 2), "syslog.2.gz": date(2024, time.February, 2),
+			},
+			want: []time.Time{date(2023, time.November, 20), date(2023, time.December, 20), date(2024, time.February, 1)},
+		},
+		{
+			name:     "dateext rotation date bounds the file",
+			lines:    []line{{"messages-20230110", stamp(time.December, 30)}},
+			modTimes: map[string]time.Time{"messages-20230110": date(2025, time.June, 1)},
+			want:     []time.Time{date(2022, time.December, 30)},
+		},
+		{
+			name:     "events with a year are kept",
+			lines:    []line{{"syslog", date(2019, time.May, 1)}, {"syslog", stamp(time.May, 2)}},
+			modTimes: map[string]time.Time{"syslog": date(2024, time.May, 3)},
+			want:     []time.Time{date(2019, time.May, 1), date(2024, time.May, 2)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []data.LogEvent
+			for _, l := range tt.lines {
+				events = append(events, data.LogEvent{Source: l.source, Date: l.date})
+			}
+
+			ResolveYears(events, tt.modTimes, tt.reference)
+
+			for i, event := range events {
+				if !event.Date.Equal(tt.want[i]) {
+					t.Errorf("event %d of %s = %s, want %s", i, event.Source, event.Date, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseReferenceDate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+		err   bool
+	}{
+		{value: "2024-03-01", want: time.Date(2024, 3, 1, 23, 59, 59, 0, time.Local)},
+		{value: "2024-03-01T10:00:00Z", want: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)},
+		{value: "2024-03-01T10:00:00+02:00", want: time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)},
+		{value: "01/03/2024", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseReferenceDate(tt.value)
+			if (err != nil) != tt.err {
+				t.Fatalf("error = %v, want error %v", err, tt.err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ParseReferenceDate(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
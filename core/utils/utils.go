@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
@@ -37,6 +39,8 @@ func GetActionType(logLine string) data.ActionType {
 	switch {
 	case strings.Contains(logLine, "New USB device found"):
 		return data.Connected
+	case strings.Contains(logLine, "USB device number") && strings.Contains(logLine, " using "):
+		return data.Connected
 	case strings.Contains(logLine, "Product: "):
 		return data.Connected
 	case strings.Contains(logLine, "Manufacturer: "):
@@ -65,14 +69,42 @@ func FilterEvents(params data.ParseParams, events []data.Event) []data.Event {
 		}).([]data.Event)
 	}
 
+	//filter by connection date
+	if !params.Since.IsZero() || !params.Until.IsZero() {
+		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Filter devices connected between %s and %s}}::green", time.Now().Format(time.Stamp),
+			formatRangeBound(params.Since), formatRangeBound(params.Until)))
+
+		filtered = funk.Filter(filtered, func(event data.Event) bool {
+			if event.ConnectedTime.IsZero() {
+				return false
+			}
+			return (params.Since.IsZero() || !event.ConnectedTime.Before(params.Since)) &&
+				(params.Until.IsZero() || !event.ConnectedTime.After(params.Until))
+		}).([]data.Event)
+	}
+
 	//check by whitelist
 	if params.CheckWl {
 		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Checking devices by white list}}::green", time.Now().Format(time.Stamp)))
 
 		for i, event := range filtered {
-			if IsInWhiteList(event.SerialNumber) {
-				filtered[i].Trusted = true
+			if rule := MatchWhiteList(event); rule != nil {
+				filtered[i].Trusted = !rule.Deny
+				filtered[i].WhiteListRule = rule.String()
+				filtered[i].WhiteListComment = rule.Commentary
+				filtered[i].Ignored = rule.IsIgnore && !rule.Deny
+			}
+		}
+
+		//hide devices the whitelist ignores
+		if !params.ShowIgnored {
+			visible := funk.Filter(filtered, func(event data.Event) bool {
+				return !event.Ignored
+			}).([]data.Event)
+			if hidden := len(filtered) - len(visible); hidden > 0 {
+				_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Hidden %d devices ignored by the whitelist (use --show-ignored to list them)}}::green", time.Now().Format(time.Stamp), hidden))
 			}
+			filtered = visible
 		}
 	}
 
@@ -118,14 +150,37 @@ func FilterEvents(params data.ParseParams, events []data.Event) []data.Event {
 	return filtered
 }
 
+// eventKey identifies the same connection seen in several logs (e.g. syslog and kern.log)
+type eventKey struct {
+	connected time.Time
+	monotonic time.Duration
+	host      string
+	scanHost  string
+	port      string
+	vid       string
+	pid       string
+	serial    string
+}
+
 func RemoveDuplicates(events []data.Event) []data.Event {
 	// Use map for O(n) performance instead of O(n²)
-	seen := make(map[time.Time]bool)
+	seen := make(map[eventKey]bool)
 	clearEvents := make([]data.Event, 0, len(events))
 
 	for _, event := range events {
-		if !seen[event.ConnectedTime] {
-			seen[event.ConnectedTime] = true
+		key := eventKey{
+			// Normalize the location so equal instants from different zones match
+			connected: event.ConnectedTime.UTC(),
+			monotonic: event.Monotonic,
+			host:      event.Host,
+			scanHost:  event.ScanHost,
+			port:      event.ConnectionPort,
+			vid:       event.Vid,
+			pid:       event.Pid,
+			serial:    event.SerialNumber,
+		}
+		if !seen[key] {
+			seen[key] = true
 			clearEvents = append(clearEvents, event)
 		}
 	}
@@ -133,29 +188,64 @@ func RemoveDuplicates(events []data.Event) []data.Event {
 	return clearEvents
 }
 
-func TimeStampToTime(timeStampString string) time.Time {
-	layout := "Jan _2 15:04:05"
-	pTime, err := time.Parse(layout, timeStampString)
-	if err != nil {
-		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Failed to parse timestamp '%s': %s}}::red", time.Now().Format(time.Stamp), timeStampString, err.Error()))
-		return time.Time{}
+// EventID returns a stable identifier of an event, made of what tells device
+// sessions apart in RemoveDuplicates, so a session keeps its ID across scans,
+// exports and the events database
+func EventID(event data.Event) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%s|%s|%s|%s|%s",
+		event.ConnectedTime.UTC().Format(time.RFC3339Nano), event.Monotonic,
+		event.Host, event.ConnectionPort, event.Vid, event.Pid, event.SerialNumber)))
+	return hex.EncodeToString(sum[:4])
+}
+
+// WithoutEvidence returns copies of events without their evidence lines, for
+// exports that don't ask for them
+func WithoutEvidence(events []data.Event) []data.Event {
+	stripped := make([]data.Event, len(events))
+	for i, event := range events {
+		event.Evidence = nil
+		stripped[i] = event
 	}
+	return stripped
+}
 
-	// Linux syslog doesn't include year in timestamps, so we need to add it
-	// Use current year, but if the parsed month is in the future, use previous year
-	now := time.Now()
-	year := now.Year()
+// PrintEvidence prints the log lines the events with an ID starting with id
+// were built from, with the file, line number and byte offset of each
+func PrintEvidence(events []data.Event, id string) error {
+	id = strings.ToLower(id)
+	location := color.New(color.FgCyan).SprintFunc()
+	offset := color.New(color.FgHiBlack).SprintFunc()
 
-	// If the event month is greater than current month, it must be from last year
-	// e.g., current date is Jan 2024, but log shows Dec - must be Dec 2023
-	if pTime.Month() > now.Month() {
-		year--
-	}
+	found := 0
+	for _, event := range events {
+		if !strings.HasPrefix(event.ID, id) {
+			continue
+		}
+		found++
 
-	// Reconstruct time with the correct year
-	pTime = time.Date(year, pTime.Month(), pTime.Day(), pTime.Hour(), pTime.Minute(), pTime.Second(), 0, time.Local)
+		host := event.Host
+		if event.ScanHost != "" {
+			host = fmt.Sprintf("%s (scanned host %s)", event.Host, event.ScanHost)
+		}
+		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Event %s: %s:%s %s %s, serial %s, host %s, port %s, connected %s}}::green",
+			time.Now().Format(time.Stamp), event.ID, event.Vid, event.Pid, event.ManufacturerName, event.ProductName,
+			event.SerialNumber, host, event.ConnectionPort, FormatConnectedTime(event)))
+
+		if len(event.Evidence) == 0 {
+			_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: no evidence recorded for event %s, it was stored by an older version}}::yellow",
+				time.Now().Format(time.Stamp), event.ID))
+			continue
+		}
+		for _, evidence := range event.Evidence {
+			fmt.Printf("%s %s\n    %s\n", location(fmt.Sprintf("%s:%d", evidence.Source, evidence.Line)),
+				offset(fmt.Sprintf("(offset %d)", evidence.Offset)), evidence.LogLine)
+		}
+	}
 
-	return pTime
+	if found == 0 {
+		return fmt.Errorf("no event with ID %s", id)
+	}
+	return nil
 }
 
 func ExpandPath(path string) (string, error) {
@@ -171,6 +261,133 @@ func ExpandPath(path string) (string, error) {
 	return filepath.Join(usr.HomeDir, path[1:]), nil
 }
 
+// ReportTimeLayout is used for event times in tables and PDF reports
+const ReportTimeLayout = "Jan _2 2006 15:04:05 MST"
+
+// FormatConnectedTime renders the connection time of an event. Events read from
+// dmesg output only know the time since boot.
+func FormatConnectedTime(event data.Event) string {
+	if event.ConnectedTime.IsZero() && event.Monotonic > 0 {
+		return fmt.Sprintf("boot +%.6fs", event.Monotonic.Seconds())
+	}
+	return event.ConnectedTime.Format(ReportTimeLayout)
+}
+
+// FormatSessionDuration renders how long a device stayed connected
+func FormatSessionDuration(event data.Event) string {
+	duration := event.Duration.Round(time.Second).String()
+	switch {
+	case event.SessionOpen:
+		return "still connected/unknown"
+	case event.Duration < time.Second:
+		duration = "<1s"
+	}
+	if event.DisconnectInferred {
+		// The device was gone by then at the latest
+		return duration + " (inferred)"
+	}
+	return duration
+}
+
+// FormatInterfaces renders the interface classes of a device with their drivers
+func FormatInterfaces(event data.Event) string {
+	var parts []string
+	seen := make(map[string]bool)
+	for _, iface := range event.Interfaces {
+		part := iface.Driver
+		if iface.Class != "" {
+			part = fmt.Sprintf("%s (%s)", iface.Class, iface.Driver)
+		}
+		if !seen[part] {
+			seen[part] = true
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return "None"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// FormatStorage renders the block device of a storage device with its partitions,
+// filesystems and mount points
+func FormatStorage(event data.Event) string {
+	if event.BlockDevice == "" {
+		return "None"
+	}
+
+	disk := event.BlockDevice
+	if event.Capacity != "" {
+		disk = fmt.Sprintf("%s %s", disk, event.Capacity)
+	}
+
+	names := append([]string(nil), event.Partitions...)
+	volumes := make(map[string]data.Volume, len(event.Volumes))
+	for _, volume := range event.Volumes {
+		if _, ok := volumes[volume.Device]; !ok && !funk.ContainsString(names, volume.Device) {
+			names = append(names, volume.Device)
+		}
+		volumes[volume.Device] = volume
+	}
+	if len(names) == 0 {
+		return disk
+	}
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		part := name
+		volume := volumes[name]
+		if volume.Filesystem != "" {
+			part += " " + volume.Filesystem
+		}
+		if volume.Label != "" {
+			part += fmt.Sprintf(" %q", volume.Label)
+		}
+		if volume.MountPoint != "" {
+			part += " on " + volume.MountPoint
+		}
+		parts = append(parts, part)
+	}
+
+	return fmt.Sprintf("%s: %s", disk, strings.Join(parts, ", "))
+}
+
+// FormatUsers renders the users logged in when a device was connected, with
+// failed logins around that time
+func FormatUsers(event data.Event) string {
+	users := "None"
+	if len(event.Users) > 0 {
+		users = strings.Join(event.Users, ", ")
+	}
+	if len(event.FailedLogins) > 0 {
+		users += fmt.Sprintf(" (failed logins: %s)", strings.Join(event.FailedLogins, ", "))
+	}
+	return users
+}
+
+func formatRangeBound(t time.Time) string {
+	if t.IsZero() {
+		return "any time"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// FormatWhiteList renders the whitelist rule a device matched, marking ignore rules
+func FormatWhiteList(event data.Event) string {
+	if event.Ignored {
+		return event.WhiteListRule + " (ignored)"
+	}
+	return event.WhiteListRule
+}
+
+// FormatSpeed renders the negotiated speed of a device
+func FormatSpeed(event data.Event) string {
+	if event.Speed == "" {
+		return "None"
+	}
+	return event.Speed
+}
+
 func PrintEvents(e []data.Event) {
 	// Configure colorized renderer
 	headerTint := renderer.Tint{
@@ -180,16 +397,37 @@ func PrintEvents(e []data.Event) {
 	columnTint := renderer.Tint{
 		FG: renderer.Colors{color.FgWhite},
 		Columns: []renderer.Tint{
-			{FG: renderer.Colors{color.FgGreen}}, // Connected time
-			{FG: renderer.Colors{color.FgWhite}}, // Host
-			{FG: renderer.Colors{color.FgWhite}}, // VID
-			{FG: renderer.Colors{color.FgWhite}}, // PID
-			{FG: renderer.Colors{color.FgWhite}}, // Manufacturer
-			{FG: renderer.Colors{color.FgWhite}}, // Product
-			{FG: renderer.Colors{color.FgHiRed}}, // Serial Number (default red for untrusted)
+			{FG: renderer.Colors{color.FgGreen}},  // Connected time
+			{FG: renderer.Colors{color.FgWhite}},  // Host
+			{FG: renderer.Colors{color.FgWhite}},  // VID
+			{FG: renderer.Colors{color.FgWhite}},  // PID
+			{FG: renderer.Colors{color.FgWhite}},  // Manufacturer
+			{FG: renderer.Colors{color.FgWhite}},  // Product
+			{FG: renderer.Colors{color.FgHiRed}},  // Serial Number (default red for untrusted)
+			{FG: renderer.Colors{color.FgWhite}},  // Duration
+			{FG: renderer.Colors{color.FgWhite}},  // Speed
+			{FG: renderer.Colors{color.FgCyan}},   // Interfaces
+			{FG: renderer.Colors{color.FgWhite}},  // Storage
+			{FG: renderer.Colors{color.FgYellow}}, // Users
+			{FG: renderer.Colors{color.FgGreen}},  // Whitelist rule
+			{FG: renderer.Colors{color.FgWhite}},  // Whitelist comment
 		},
 	}
 
+	// Events of a multi-host scan also name the configured host they came from
+	multiHost := false
+	for _, event := range e {
+		if event.ScanHost != "" {
+			multiHost = true
+			break
+		}
+	}
+	if multiHost {
+		columnTint.Columns = append([]renderer.Tint{{FG: renderer.Colors{color.FgCyan}}}, columnTint.Columns...)
+	}
+	// ID, for luft events --explain
+	columnTint.Columns = append([]renderer.Tint{{FG: renderer.Colors{color.FgMagenta}}}, columnTint.Columns...)
+
 	borderTint := renderer.Tint{
 		FG: renderer.Colors{color.FgHiBlack},
 	}
@@ -206,7 +444,12 @@ func PrintEvents(e []data.Event) {
 	)
 
 	// Set header
-	table.Header("Connected", "Host", "VID", "PID", "Manufacturer", "Product", "Serial Number")
+	header := []any{"Connected", "Host", "VID", "PID", "Manufacturer", "Product", "Serial Number", "Duration", "Speed", "Interfaces", "Storage", "Users", "Whitelist", "Comment"}
+	if multiHost {
+		header = append([]any{"Scanned Host"}, header...)
+	}
+	header = append([]any{"ID"}, header...)
+	table.Header(header...)
 
 	// Add data rows
 	greenSerial := color.New(color.FgGreen).SprintFunc()
@@ -221,15 +464,27 @@ func PrintEvents(e []data.Event) {
 			serialNumber = redSerial(event.SerialNumber)
 		}
 
-		table.Append(
-			event.ConnectedTime.Format("Jan _2 15:04:05"),
+		row := []any{
+			FormatConnectedTime(event),
 			event.Host,
 			event.Vid,
 			event.Pid,
 			event.ManufacturerName,
 			event.ProductName,
 			serialNumber,
-		)
+			FormatSessionDuration(event),
+			FormatSpeed(event),
+			FormatInterfaces(event),
+			FormatStorage(event),
+			FormatUsers(event),
+			FormatWhiteList(event),
+			event.WhiteListComment,
+		}
+		if multiHost {
+			row = append([]any{event.ScanHost}, row...)
+		}
+		row = append([]any{event.ID}, row...)
+		table.Append(row...)
 	}
 
 	// Render the table
@@ -255,9 +510,18 @@ func GenerateReport(events []data.Event, fn string) error {
 	return nil
 }
 
-var colWidths = map[string]float64{"C": 30, "H": 30, "V": 10, "P": 10, "PR": 70, "M": 70, "S": 60}
+var colWidths = map[string]float64{"C": 38, "H": 22, "V": 9, "P": 9, "PR": 40, "M": 40, "S": 36, "D": 24, "SP": 19, "I": 40}
 var rowHeight = 6.5
 
+// detailRowWidth is the width of all report columns together
+func detailRowWidth() float64 {
+	var width float64
+	for _, w := range colWidths {
+		width += w
+	}
+	return width
+}
+
 func newReport() *gofpdf.Fpdf {
 	pdf := gofpdf.New("L", "mm", "A4", "")
 	pdf.AddPage()
@@ -274,7 +538,7 @@ func newReport() *gofpdf.Fpdf {
 }
 
 func header(pdf *gofpdf.Fpdf) *gofpdf.Fpdf {
-	pdf.SetFont("Times", "B", 12)
+	pdf.SetFont("Times", "B", 9)
 	pdf.SetFillColor(240, 240, 240)
 	pdf.CellFormat(colWidths["C"], rowHeight, "CONNECTED", "1", 0, "", true, 0, "")
 	pdf.CellFormat(colWidths["H"], rowHeight, "HOST", "1", 0, "", true, 0, "")
@@ -283,17 +547,20 @@ func header(pdf *gofpdf.Fpdf) *gofpdf.Fpdf {
 	pdf.CellFormat(colWidths["M"], rowHeight, "MANUFACTURER", "1", 0, "", true, 0, "")
 	pdf.CellFormat(colWidths["PR"], rowHeight, "PRODUCT", "1", 0, "", true, 0, "")
 	pdf.CellFormat(colWidths["S"], rowHeight, "SERIAL NUMBER", "1", 0, "", true, 0, "")
+	pdf.CellFormat(colWidths["D"], rowHeight, "DURATION", "1", 0, "", true, 0, "")
+	pdf.CellFormat(colWidths["SP"], rowHeight, "SPEED", "1", 0, "", true, 0, "")
+	pdf.CellFormat(colWidths["I"], rowHeight, "INTERFACES", "1", 0, "", true, 0, "")
 	return pdf
 }
 
 func table(pdf *gofpdf.Fpdf, tbl []data.Event) *gofpdf.Fpdf {
-	pdf.SetFont("Helvetica", "", 10)
+	pdf.SetFont("Helvetica", "", 8)
 	pdf.SetFillColor(255, 255, 255)
 	pdf.Ln(-1)
 
 	for _, event := range tbl {
 		pdf.SetTextColor(75, 177, 24)
-		pdf.CellFormat(colWidths["C"], rowHeight, event.ConnectedTime.Format("Jan _2 15:04:05"), "1", 0, "L", true, 0, "")
+		pdf.CellFormat(colWidths["C"], rowHeight, FormatConnectedTime(event), "1", 0, "L", true, 0, "")
 		pdf.SetTextColor(0, 0, 0)
 		pdf.CellFormat(colWidths["H"], rowHeight, event.Host, "1", 0, "L", false, 0, "")
 		pdf.CellFormat(colWidths["V"], rowHeight, event.Vid, "1", 0, "L", false, 0, "")
@@ -306,7 +573,43 @@ func table(pdf *gofpdf.Fpdf, tbl []data.Event) *gofpdf.Fpdf {
 			pdf.SetTextColor(255, 24, 0)
 		}
 		pdf.CellFormat(colWidths["S"], rowHeight, event.SerialNumber, "1", 0, "L", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+		pdf.CellFormat(colWidths["D"], rowHeight, FormatSessionDuration(event), "1", 0, "L", false, 0, "")
+		pdf.CellFormat(colWidths["SP"], rowHeight, FormatSpeed(event), "1", 0, "L", false, 0, "")
+		pdf.CellFormat(colWidths["I"], rowHeight, FormatInterfaces(event), "1", 0, "L", false, 0, "")
 		pdf.Ln(-1)
+
+		// ID, storage, user and whitelist details don't fit a column, they go to a full-width row under the device
+		details := []string{"ID: " + event.ID}
+		if event.ScanHost != "" {
+			details = append(details, "Scanned host: "+event.ScanHost)
+		}
+		if event.BlockDevice != "" {
+			details = append(details, "Storage: "+FormatStorage(event))
+		}
+		if len(event.Users) > 0 || len(event.FailedLogins) > 0 {
+			details = append(details, "Users: "+FormatUsers(event))
+		}
+		if event.WhiteListRule != "" {
+			details = append(details, "Whitelist: "+FormatWhiteList(event))
+		}
+		if event.WhiteListComment != "" {
+			details = append(details, "Comment: "+event.WhiteListComment)
+		}
+		pdf.CellFormat(detailRowWidth(), rowHeight, strings.Join(details, "  |  "), "1", 0, "L", false, 0, "")
+		pdf.Ln(-1)
+
+		// Evidence lines, when the export asks for them
+		if len(event.Evidence) > 0 {
+			pdf.SetFont("Courier", "", 6.5)
+			for _, evidence := range event.Evidence {
+				pdf.MultiCell(detailRowWidth(), 3.5, fmt.Sprintf("%s:%d (offset %d)  %s",
+					evidence.Source, evidence.Line, evidence.Offset, evidence.LogLine), "LR", "L", false)
+			}
+			pdf.CellFormat(detailRowWidth(), 0, "", "T", 0, "L", false, 0, "")
+			pdf.Ln(-1)
+			pdf.SetFont("Helvetica", "", 8)
+		}
 	}
 
 	return pdf
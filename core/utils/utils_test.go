@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pixfid/luft/data"
+	"github.com/pixfid/luft/usbids"
+)
+
+// TestFilterEventsWhiteList checks devices against testdata/whitelist.yaml,
+// which ignores one vendor, allows a stick and blocks another vendor
+func TestFilterEventsWhiteList(t *testing.T) {
+	wl = nil
+	t.Cleanup(func() { wl = nil })
+	if err := LoadWhiteList(filepath.Join("testdata", "whitelist.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	// device is what a filtered event is checked on
+	type device struct {
+		serial  string
+		trusted bool
+		ignored bool
+		rule    string
+		comment string
+	}
+	key := device{serial: "YK1", trusted: true, ignored: true, rule: "admin keys", comment: "issued to every admin"}
+	stick := device{serial: "4C530001", trusted: true, rule: "backup stick", comment: "kept in the safe"}
+	ducky := device{serial: "D1", rule: "attack tools", comment: "rubber ducky"}
+	unknown := device{serial: "X1"}
+
+	tests := []struct {
+		name   string
+		params data.ParseParams
+		want   []device
+	}{
+		{name: "ignored devices hidden", params: data.ParseParams{CheckWl: true}, want: []device{stick, ducky, unknown}},
+		{name: "show ignored", params: data.ParseParams{CheckWl: true, ShowIgnored: true}, want: []device{key, stick, ducky, unknown}},
+		{name: "untrusted only", params: data.ParseParams{CheckWl: true, Untrusted: true}, want: []device{ducky, unknown}},
+		{name: "no whitelist check", params: data.ParseParams{}, want: []device{
+			{serial: "YK1"}, {serial: "4C530001"}, {serial: "D1"}, {serial: "X1"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			connected := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+			events := []data.Event{
+				{Vid: "1050", Pid: "0407", SerialNumber: "YK1", ConnectedTime: connected},
+				{Vid: "0781", Pid: "5567", SerialNumber: "4C530001", ConnectedTime: connected.Add(time.Minute)},
+				{Vid: "03eb", Pid: "2401", SerialNumber: "D1", ConnectedTime: connected.Add(2 * time.Minute)},
+				{Vid: "dead", Pid: "beef", SerialNumber: "X1", ConnectedTime: connected.Add(3 * time.Minute)},
+			}
+
+			var got []device
+			for _, event := range FilterEvents(tt.params, events) {
+				got = append(got, device{event.SerialNumber, event.Trusted, event.Ignored, event.WhiteListRule, event.WhiteListComment})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("devices = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFilterEventsDescriptorStrings checks that manufacturer and product rules
+// match the strings a device reported, not the usb.ids names of its VID:PID
+func TestFilterEventsDescriptorStrings(t *testing.T) {
+	ids := filepath.Join(t.TempDir(), "usb.ids")
+	if err := os.WriteFile(ids, []byte("0781  SanDisk Corp.\n\t5567  Cruzer Blade\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := usbids.LoadFromFile(ids); err != nil {
+		t.Fatal(err)
+	}
+
+	wl = nil
+	t.Cleanup(func() { wl = nil })
+	if err := AddWhiteListRule(WhiteListRule{Name: "cruzer", Manufacturer: "SanDisk*", Product: "Cruzer Blade"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name                  string
+		manufacturer, product string
+		trusted               bool
+	}{
+		{"reported strings", "SanDisk", "Cruzer Blade", true},
+		{"spoofed VID:PID", "Hak5", "Rubber Ducky", false},
+		{"no strings logged", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := []data.Event{{Vid: "0781", Pid: "5567", ManufacturerName: tt.manufacturer, ProductName: tt.product}}
+			got := FilterEvents(data.ParseParams{CheckWl: true}, events)
+			if len(got) != 1 || got[0].Trusted != tt.trusted {
+				t.Errorf("events = %+v, want trusted %v", got, tt.trusted)
+			}
+		})
+	}
+}
+
+func TestFormatWhiteList(t *testing.T) {
+	tests := []struct {
+		event data.Event
+		want  string
+	}{
+		{data.Event{}, ""},
+		{data.Event{WhiteListRule: "backup stick"}, "backup stick"},
+		{data.Event{WhiteListRule: "admin keys", Ignored: true}, "admin keys (ignored)"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatWhiteList(tt.event); got != tt.want {
+			t.Errorf("FormatWhiteList(%+v) = %q, want %q", tt.event, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,498 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/i582/cfmt/cmd/cfmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/olekukonko/tablewriter/renderer"
+	"go.yaml.in/yaml/v3"
+)
+
+// entryRule validates an entry given outside of a whitelist file
+func entryRule(entry WhiteListEntry) (WhiteListRule, error) {
+	rule := WhiteListRule{
+		Name:         entry.Name,
+		Serial:       entry.Serial,
+		Manufacturer: entry.Manufacturer,
+		Product:      entry.Product,
+		Host:         entry.Host,
+		Port:         entry.Port,
+		IsIgnore:     entry.Ignore,
+		Deny:         entry.Block,
+		Commentary:   entry.Comment,
+	}
+
+	for _, id := range []struct{ name, value string }{{"vid", entry.Vid}, {"pid", entry.Pid}} {
+		if id.value != "" && !validUSBID(id.value) {
+			return rule, fmt.Errorf("%s must be 4 hex digits or a glob, got %q", id.name, id.value)
+		}
+	}
+	rule.Vid, rule.Pid = entry.Vid, entry.Pid
+
+	if entry.ValidFrom != "" {
+		t, _, err := parseValidity(entry.ValidFrom)
+		if err != nil {
+			return rule, fmt.Errorf("valid_from: %w", err)
+		}
+		rule.ValidFrom = t
+	}
+	if entry.ValidUntil != "" {
+		t, dateOnly, err := parseValidity(entry.ValidUntil)
+		if err != nil {
+			return rule, fmt.Errorf("valid_until: %w", err)
+		}
+		if dateOnly {
+			t = t.Add(24*time.Hour - time.Nanosecond)
+		}
+		rule.ValidUntil = t
+	}
+
+	return rule, rule.compile()
+}
+
+// udevLine renders a serial rule in the udev rules format
+func udevLine(serial string, ignore bool, comment string) string {
+	flag := "0"
+	if ignore {
+		flag = "1"
+	}
+	return fmt.Sprintf("ATTRS{serial}==\"%s\",ENV{%s}=\"%s\" # %s", serial, udevFlag, flag, comment)
+}
+
+// udevEntryLine renders an entry as a udev rule, udev rules only allow or ignore
+// a serial number
+func udevEntryLine(entry WhiteListEntry) (string, error) {
+	serialOnly := entry
+	serialOnly.Name, serialOnly.Serial, serialOnly.Ignore, serialOnly.Comment = "", "", false, ""
+	switch {
+	case entry.Serial == "":
+		return "", fmt.Errorf("udev whitelist rules need a serial number")
+	case entry.Block:
+		return "", fmt.Errorf("udev whitelist rules can't block devices, use a YAML or JSON whitelist")
+	case serialOnly != WhiteListEntry{}:
+		return "", fmt.Errorf("udev whitelist rules only match serial numbers, use a YAML or JSON whitelist for other criteria")
+	case strings.ContainsAny(entry.Serial, "\"\n"):
+		return "", fmt.Errorf("serial number can't be written to a udev rule: %q", entry.Serial)
+	}
+
+	comment := entry.Comment
+	if comment == "" {
+		comment = entry.Name
+	}
+	return udevLine(entry.Serial, entry.Ignore, comment), nil
+}
+
+// AddWhiteListEntry appends a rule to the whitelist file at wlPath, creating it
+// when missing. The file keeps its format; YAML files keep their comments.
+func AddWhiteListEntry(wlPath string, entry WhiteListEntry) error {
+	if _, err := entryRule(entry); err != nil {
+		return err
+	}
+
+	content, format, err := readEditableWhiteList(wlPath)
+	if err != nil {
+		return err
+	}
+
+	var updated []byte
+	switch format {
+	case "udev":
+		line, err := udevEntryLine(entry)
+		if err != nil {
+			return err
+		}
+		updated = appendLines(content, line)
+	case "usbguard":
+		rule, err := usbguardRule(entry)
+		if err != nil {
+			return err
+		}
+		if entry.Comment != "" {
+			updated = appendLines(content, "# "+entry.Comment, rule)
+		} else {
+			updated = appendLines(content, rule)
+		}
+	case "yaml":
+		updated, err = editYAMLRules(content, func(rules *yaml.Node) error {
+			var node yaml.Node
+			if err := node.Encode(entry); err != nil {
+				return err
+			}
+			rules.Content = append(rules.Content, &node)
+			return nil
+		})
+	case "json":
+		updated, err = editJSONRules(content, func(entries []WhiteListEntry) []WhiteListEntry {
+			return append(entries, entry)
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update whitelist: %w", err)
+	}
+
+	return writeWhiteListFile(wlPath, updated)
+}
+
+// RemoveWhiteListEntries removes the rules of the whitelist file at wlPath whose
+// name, serial number or VID:PID is key, and returns how many were removed
+func RemoveWhiteListEntries(wlPath string, key string) (int, error) {
+	content, format, err := readEditableWhiteList(wlPath)
+	if err != nil {
+		return 0, err
+	}
+	if len(content) == 0 {
+		return 0, fmt.Errorf("whitelist %s does not exist", wlPath)
+	}
+
+	removed := 0
+	var updated []byte
+	switch format {
+	case "udev", "usbguard":
+		var kept []string
+		for i, line := range strings.Split(string(content), "\n") {
+			if lineMatchesKey(wlPath, i+1, line, format, key) {
+				removed++
+				continue
+			}
+			kept = append(kept, line)
+		}
+		updated = []byte(strings.Join(kept, "\n"))
+	case "yaml":
+		updated, err = editYAMLRules(content, func(rules *yaml.Node) error {
+			var kept []*yaml.Node
+			for _, node := range rules.Content {
+				var entry WhiteListEntry
+				if err := node.Decode(&entry); err != nil {
+					return err
+				}
+				if entryMatchesKey(entry, key) {
+					removed++
+					continue
+				}
+				kept = append(kept, node)
+			}
+			rules.Content = kept
+			return nil
+		})
+	case "json":
+		updated, err = editJSONRules(content, func(entries []WhiteListEntry) []WhiteListEntry {
+			var kept []WhiteListEntry
+			for _, entry := range entries {
+				if entryMatchesKey(entry, key) {
+					removed++
+					continue
+				}
+				kept = append(kept, entry)
+			}
+			return kept
+		})
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to update whitelist: %w", err)
+	}
+
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, writeWhiteListFile(wlPath, updated)
+}
+
+// entryMatchesKey reports whether key names an entry by name, serial number or VID:PID
+func entryMatchesKey(entry WhiteListEntry, key string) bool {
+	if entry.Name != "" && entry.Name == key {
+		return true
+	}
+	if entry.Serial != "" && (entry.Serial == key || entry.Serial == globEscape(key)) {
+		return true
+	}
+	if vid, pid, ok := strings.Cut(key, ":"); ok && (entry.Vid != "" || entry.Pid != "") {
+		return strings.EqualFold(entry.Vid, vid) && strings.EqualFold(entry.Pid, pid)
+	}
+	return false
+}
+
+// lineMatchesKey reports whether a udev or USBGuard rules line holds a rule named by key
+func lineMatchesKey(wlPath string, lineNumber int, line string, format string, key string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return false
+	}
+
+	var rules []WhiteListRule
+	if format == "udev" {
+		rule, _, err := udevRule(line)
+		if err != nil {
+			return false
+		}
+		rules = append(rules, rule)
+	} else {
+		var err error
+		rules, _, err = usbguardLineRules(fmt.Sprintf("%s:%d", filepath.Base(wlPath), lineNumber), line)
+		if err != nil {
+			return false
+		}
+	}
+
+	for i := range rules {
+		if entryMatchesKey(ruleEntry(&rules[i]), key) {
+			return true
+		}
+	}
+	return false
+}
+
+// readEditableWhiteList returns the content and format of a whitelist about to be
+// edited. A missing file is empty; a file with errors must be fixed first.
+func readEditableWhiteList(wlPath string) ([]byte, string, error) {
+	content, err := os.ReadFile(wlPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, whiteListFormat(wlPath, nil), nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	format := whiteListFormat(wlPath, content)
+	if format == "yaml" || format == "json" {
+		if _, err := parseWhiteListFile(wlPath, content, format); err != nil {
+			return nil, "", fmt.Errorf("fix the whitelist before editing it:\n%w", err)
+		}
+	}
+	return content, format, nil
+}
+
+// appendLines adds lines to a line based whitelist. They go before the rules
+// that end it, a udev LABEL or a USBGuard catch-all block rule, which would
+// otherwise shadow them.
+func appendLines(content []byte, lines ...string) []byte {
+	existing := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	if len(content) == 0 {
+		existing = nil
+	}
+
+	at := len(existing)
+	for at > 0 {
+		line := strings.TrimSpace(existing[at-1])
+		if !strings.HasPrefix(line, "LABEL=") && line != "block" && line != "reject" {
+			break
+		}
+		at--
+	}
+
+	updated := append(append(append([]string{}, existing[:at]...), lines...), existing[at:]...)
+	return []byte(strings.Join(updated, "\n") + "\n")
+}
+
+// editYAMLRules applies edit to the rules list of a YAML whitelist, keeping the
+// rest of the document and its comments
+func editYAMLRules(content []byte, edit func(rules *yaml.Node) error) ([]byte, error) {
+	var doc yaml.Node
+	if len(bytes.TrimSpace(content)) == 0 {
+		if err := doc.Encode(WhiteListFile{Version: WhiteListFileVersion, Rules: []WhiteListEntry{}}); err != nil {
+			return nil, err
+		}
+	} else if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+
+	root := &doc
+	if root.Kind == yaml.DocumentNode {
+		root = root.Content[0]
+	}
+	var rules *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "rules" {
+			rules = root.Content[i+1]
+		}
+	}
+	if rules == nil {
+		return nil, fmt.Errorf("whitelist has no rules list")
+	}
+	// An empty list is written inline, rules go on their own lines
+	rules.Style = 0
+
+	if err := edit(rules); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// editJSONRules applies edit to the rules of a JSON whitelist
+func editJSONRules(content []byte, edit func(entries []WhiteListEntry) []WhiteListEntry) ([]byte, error) {
+	file := WhiteListFile{Version: WhiteListFileVersion}
+	if len(bytes.TrimSpace(content)) > 0 {
+		if err := json.Unmarshal(content, &file); err != nil {
+			return nil, err
+		}
+	}
+
+	file.Rules = edit(file.Rules)
+	if file.Rules == nil {
+		file.Rules = []WhiteListEntry{}
+	}
+	return json.MarshalIndent(file, "", " ")
+}
+
+// writeWhiteListFile replaces the whitelist at wlPath atomically, keeping the
+// previous version as wlPath.bak
+func writeWhiteListFile(wlPath string, content []byte) error {
+	mode := fs.FileMode(0644)
+	if info, err := os.Stat(wlPath); err == nil {
+		mode = info.Mode().Perm()
+
+		previous, err := os.ReadFile(wlPath)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(wlPath+".bak", previous, mode); err != nil {
+			return fmt.Errorf("failed to back up %s: %w", wlPath, err)
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(wlPath), "."+filepath.Base(wlPath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), wlPath)
+}
+
+// ListWhiteList prints the rules of the whitelist file at wlPath as a table
+func ListWhiteList(wlPath string) error {
+	rules, format, warnings, err := readWhiteList(wlPath)
+	for _, warning := range warnings {
+		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: %s}}::yellow", time.Now().Format(time.Stamp), warning))
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Whitelist %s (%s): %d rules}}::green", time.Now().Format(time.Stamp), wlPath, format, len(rules)))
+
+	config := renderer.ColorizedConfig{
+		Header: renderer.Tint{
+			FG: renderer.Colors{color.FgWhite, color.Bold},
+		},
+		Column: renderer.Tint{
+			FG: renderer.Colors{color.FgWhite},
+		},
+		Border: renderer.Tint{
+			FG: renderer.Colors{color.FgHiBlack},
+		},
+	}
+
+	table := tablewriter.NewTable(os.Stdout,
+		tablewriter.WithRenderer(renderer.NewColorized(config)),
+	)
+
+	table.Header("#", "Name", "VID", "PID", "Serial", "Manufacturer", "Product", "Host", "Port", "Valid", "Action", "Comment")
+
+	for i, rule := range rules {
+		table.Append(
+			fmt.Sprint(i+1),
+			rule.Name,
+			rule.Vid,
+			rule.Pid,
+			rule.Serial,
+			rule.Manufacturer,
+			rule.Product,
+			rule.Host,
+			rule.Port,
+			formatValidity(rule),
+			ruleAction(rule),
+			rule.Commentary,
+		)
+	}
+
+	table.Render()
+	return nil
+}
+
+// formatValidity renders the validity period of a rule
+func formatValidity(rule WhiteListRule) string {
+	switch {
+	case rule.ValidFrom.IsZero() && rule.ValidUntil.IsZero():
+		return "always"
+	case rule.ValidUntil.IsZero():
+		return "from " + rule.ValidFrom.Format(time.RFC3339)
+	case rule.ValidFrom.IsZero():
+		return "until " + rule.ValidUntil.Format(time.RFC3339)
+	}
+	return rule.ValidFrom.Format(time.RFC3339) + " - " + rule.ValidUntil.Format(time.RFC3339)
+}
+
+// ruleAction is what a rule does to the devices it matches
+func ruleAction(rule WhiteListRule) string {
+	switch {
+	case rule.Deny:
+		return "block"
+	case rule.IsIgnore:
+		return "ignore"
+	}
+	return "allow"
+}
+
+// LintWhiteList checks the whitelist file at wlPath and returns its problems:
+// entries that can't be parsed, duplicate rules and expired rules
+func LintWhiteList(wlPath string) ([]string, error) {
+	rules, _, problems, err := readWhiteList(wlPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err != nil {
+		problems = append(problems, strings.Split(err.Error(), "\n")...)
+	}
+
+	seen := make(map[WhiteListEntry]int)
+	for i := range rules {
+		rule := &rules[i]
+
+		// Rules differing only in name and comment match the same devices
+		criteria := ruleEntry(rule)
+		criteria.Name, criteria.Comment = "", ""
+		if first, ok := seen[criteria]; ok {
+			problems = append(problems, fmt.Sprintf("rule %d (%s) duplicates rule %d, it never decides a match", i+1, rule.String(), first))
+		} else {
+			seen[criteria] = i + 1
+		}
+
+		if !rule.ValidUntil.IsZero() && rule.ValidUntil.Before(time.Now()) {
+			problems = append(problems, fmt.Sprintf("rule %d (%s) expired on %s", i+1, rule.String(), rule.ValidUntil.Format(time.RFC3339)))
+		}
+	}
+
+	return problems, nil
+}
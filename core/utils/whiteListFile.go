@@ -0,0 +1,293 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// WhiteListFileVersion is the version of the structured whitelist format
+const WhiteListFileVersion = 1
+
+// WhiteListFile is a structured whitelist as written by luft
+type WhiteListFile struct {
+	Version int              `yaml:"version" json:"version"`
+	Rules   []WhiteListEntry `yaml:"rules" json:"rules"`
+}
+
+// WhiteListEntry is a rule of a structured whitelist
+type WhiteListEntry struct {
+	Name         string `yaml:"name,omitempty" json:"name,omitempty"`
+	Vid          string `yaml:"vid,omitempty" json:"vid,omitempty"`
+	Pid          string `yaml:"pid,omitempty" json:"pid,omitempty"`
+	Serial       string `yaml:"serial,omitempty" json:"serial,omitempty"`
+	Manufacturer string `yaml:"manufacturer,omitempty" json:"manufacturer,omitempty"`
+	Product      string `yaml:"product,omitempty" json:"product,omitempty"`
+	Host         string `yaml:"host,omitempty" json:"host,omitempty"`
+	Port         string `yaml:"port,omitempty" json:"port,omitempty"`
+	ValidFrom    string `yaml:"valid_from,omitempty" json:"valid_from,omitempty"`
+	ValidUntil   string `yaml:"valid_until,omitempty" json:"valid_until,omitempty"`
+	Ignore       bool   `yaml:"ignore,omitempty" json:"ignore,omitempty"`
+	Block        bool   `yaml:"block,omitempty" json:"block,omitempty"`
+	Comment      string `yaml:"comment,omitempty" json:"comment,omitempty"`
+}
+
+var (
+	usbIDRegex     = regexp.MustCompile(`^[0-9a-fA-F]{4}$`)
+	usbIDGlobRegex = regexp.MustCompile(`^[0-9a-fA-F*?\[\]!-]+$`)
+)
+
+// validUSBID reports whether value is a 4 digit hex VID or PID, or a glob of one
+func validUSBID(value string) bool {
+	if strings.ContainsAny(value, "*?[") {
+		return usbIDGlobRegex.MatchString(value)
+	}
+	return usbIDRegex.MatchString(value)
+}
+
+// whiteListFormat returns the format of a whitelist file: "json", "yaml", "udev" or "usbguard".
+// The extension decides when it is known, the content otherwise.
+func whiteListFormat(wlPath string, content []byte) string {
+	switch strings.ToLower(filepath.Ext(wlPath)) {
+	case ".json":
+		return "json"
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".rules":
+		return "udev"
+	case ".conf":
+		return "usbguard"
+	}
+
+	trimmed := bytes.TrimSpace(content)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return "json"
+	case isUSBGuardPolicy(content):
+		return "usbguard"
+	case udevRulesRegex.Match(content):
+		return "udev"
+	}
+	return "yaml"
+}
+
+// schemaErrors collects whitelist file problems with their position
+type schemaErrors struct {
+	file string
+	errs []error
+}
+
+func (s *schemaErrors) add(node *yaml.Node, format string, args ...interface{}) {
+	s.errs = append(s.errs, fmt.Errorf("%s:%d:%d: %s", s.file, node.Line, node.Column, fmt.Sprintf(format, args...)))
+}
+
+func (s *schemaErrors) err() error {
+	return errors.Join(s.errs...)
+}
+
+// parseWhiteListFile parses and validates a YAML or JSON whitelist, every
+// problem is reported with its line and column:
+//
+//	version: 1
+//	rules:
+//	  - name: office keyboards
+//	    vid: "046d"
+//	    pid: "c31c"
+//	    host: "ws-*"
+//	    valid_until: 2025-12-31
+//	    comment: issued by IT
+func parseWhiteListFile(wlPath string, content []byte, format string) ([]WhiteListRule, error) {
+	if format == "json" {
+		// The YAML parser reads JSON too, but reports JSON syntax errors poorly
+		var raw interface{}
+		if err := json.Unmarshal(content, &raw); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				line, col := offsetPosition(content, syntaxErr.Offset)
+				return nil, fmt.Errorf("%s:%d:%d: %s", wlPath, line, col, syntaxErr.Error())
+			}
+			return nil, fmt.Errorf("%s: %w", wlPath, err)
+		}
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", wlPath, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("%s: empty whitelist file", wlPath)
+	}
+
+	errs := &schemaErrors{file: wlPath}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		errs.add(root, "expected a mapping with version and rules")
+		return nil, errs.err()
+	}
+
+	var rules []WhiteListRule
+	var rulesNode *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "version":
+			if value.Kind != yaml.ScalarNode || value.Tag != "!!int" {
+				errs.add(value, "version must be an integer")
+			} else if value.Value != fmt.Sprint(WhiteListFileVersion) {
+				errs.add(value, "unsupported version %s, expected %d", value.Value, WhiteListFileVersion)
+			}
+		case "rules":
+			rulesNode = value
+		default:
+			errs.add(key, "unknown field %q, expected version or rules", key.Value)
+		}
+	}
+
+	switch {
+	case rulesNode == nil:
+		errs.add(root, "missing rules")
+	case rulesNode.Kind != yaml.SequenceNode:
+		errs.add(rulesNode, "rules must be a list")
+	default:
+		for _, node := range rulesNode.Content {
+			if rule, ok := parseWhiteListEntry(node, errs); ok {
+				rules = append(rules, rule)
+			}
+		}
+	}
+
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("%s: no whitelist rules defined", wlPath)
+	}
+	return rules, nil
+}
+
+// parseWhiteListEntry validates one rule of a structured whitelist
+func parseWhiteListEntry(node *yaml.Node, errs *schemaErrors) (WhiteListRule, bool) {
+	var rule WhiteListRule
+	if node.Kind != yaml.MappingNode {
+		errs.add(node, "rule must be a mapping")
+		return rule, false
+	}
+
+	valid := true
+	fail := func(n *yaml.Node, format string, args ...interface{}) {
+		errs.add(n, format, args...)
+		valid = false
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if seen[key.Value] {
+			fail(key, "duplicate field %q", key.Value)
+			continue
+		}
+		seen[key.Value] = true
+
+		if value.Kind != yaml.ScalarNode || value.Tag == "!!null" {
+			fail(value, "%s must be a value", key.Value)
+			continue
+		}
+
+		switch key.Value {
+		case "name":
+			rule.Name = value.Value
+		case "vid", "pid":
+			// Unquoted IDs like 0781 are YAML integers, the source text is what counts
+			if !validUSBID(value.Value) {
+				fail(value, "%s must be 4 hex digits or a glob, got %q", key.Value, value.Value)
+				continue
+			}
+			if key.Value == "vid" {
+				rule.Vid = value.Value
+			} else {
+				rule.Pid = value.Value
+			}
+		case "serial":
+			rule.Serial = value.Value
+		case "manufacturer":
+			rule.Manufacturer = value.Value
+		case "product":
+			rule.Product = value.Value
+		case "host":
+			rule.Host = value.Value
+		case "port":
+			rule.Port = value.Value
+		case "valid_from", "valid_until":
+			t, dateOnly, err := parseValidity(value.Value)
+			if err != nil {
+				fail(value, "%s: %v", key.Value, err)
+				continue
+			}
+			if key.Value == "valid_from" {
+				rule.ValidFrom = t
+			} else {
+				// A date alone is valid through the end of that day
+				if dateOnly {
+					t = t.Add(24*time.Hour - time.Nanosecond)
+				}
+				rule.ValidUntil = t
+			}
+		case "ignore", "block":
+			if value.Tag != "!!bool" {
+				fail(value, "%s must be true or false, got %q", key.Value, value.Value)
+				continue
+			}
+			flag, _ := strconv.ParseBool(strings.ToLower(value.Value))
+			if key.Value == "ignore" {
+				rule.IsIgnore = flag
+			} else {
+				rule.Deny = flag
+			}
+		case "comment":
+			rule.Commentary = value.Value
+		default:
+			fail(key, "unknown field %q", key.Value)
+		}
+	}
+
+	if !valid {
+		return rule, false
+	}
+	if err := rule.compile(); err != nil {
+		errs.add(node, "%v", err)
+		return rule, false
+	}
+	return rule, true
+}
+
+// parseValidity parses a validity bound, a date (2006-01-02) or an RFC 3339 time.
+// Dates are local days, like the --since and --until bounds.
+func parseValidity(value string) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid time %q, use 2006-01-02 or RFC 3339", value)
+	}
+	return t, false, nil
+}
+
+// offsetPosition converts a byte offset into a 1-based line and column
+func offsetPosition(content []byte, offset int64) (int, int) {
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	before := content[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
@@ -0,0 +1,218 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/i582/cfmt/cmd/cfmt"
+	"github.com/pixfid/luft/data"
+	"github.com/pixfid/luft/usbids"
+	"go.yaml.in/yaml/v3"
+)
+
+// whiteListExtensions are the file extensions of the generated whitelist formats
+var whiteListExtensions = map[string]string{
+	"udev":     "rules",
+	"yaml":     "yaml",
+	"json":     "json",
+	"usbguard": "conf",
+}
+
+// udevFlag is the udev variable written with each generated serial rule, the
+// parser reads its value as the ignore flag
+const udevFlag = "UDISKS_IGNORE"
+
+// GenerateWhiteList writes a whitelist allowing the devices of events to fileName
+// with the extension of format (udev, yaml, json, usbguard). Devices are deduplicated
+// by VID, PID and serial number, and their usb.ids names are written as comments.
+func GenerateWhiteList(events []data.Event, format string, fileName string) error {
+	entries := whiteListEntries(events)
+	if format == "udev" {
+		entries = serialEntries(entries)
+	}
+	return writeWhiteList(entries, format, fileName)
+}
+
+// serialEntries keeps the serial numbers of generated entries, all udev rules
+// can match on. Devices without one are left out with a warning.
+func serialEntries(entries []WhiteListEntry) []WhiteListEntry {
+	var serials []WhiteListEntry
+	for _, entry := range entries {
+		if entry.Serial != "" {
+			serials = append(serials, WhiteListEntry{Serial: entry.Serial, Comment: entry.Comment})
+		}
+	}
+
+	if skipped := len(entries) - len(serials); skipped > 0 {
+		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: %d devices have no serial number and can't be written as udev rules, use the yaml or json format to keep them}}::yellow",
+			time.Now().Format(time.Stamp), skipped))
+	}
+	return serials
+}
+
+// ConvertWhiteList writes the loaded whitelist rules to fileName in another format
+func ConvertWhiteList(format string, fileName string) error {
+	entries := make([]WhiteListEntry, 0, len(wl))
+	for _, rule := range wl {
+		entries = append(entries, ruleEntry(rule))
+	}
+	return writeWhiteList(entries, format, fileName)
+}
+
+// writeWhiteList writes entries to fileName with the extension of format
+func writeWhiteList(entries []WhiteListEntry, format string, fileName string) error {
+	ext, ok := whiteListExtensions[format]
+	if !ok {
+		return fmt.Errorf("unsupported whitelist format: %s (use udev, yaml, json or usbguard)", format)
+	}
+
+	count := len(entries)
+	var content []byte
+	var err error
+	switch format {
+	case "udev":
+		content, count = udevWhiteList(entries)
+	case "usbguard":
+		content, count = usbguardWhiteList(entries)
+	case "yaml":
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		err = encoder.Encode(WhiteListFile{Version: WhiteListFileVersion, Rules: entries})
+		content = buf.Bytes()
+	case "json":
+		content, err = json.MarshalIndent(WhiteListFile{Version: WhiteListFileVersion, Rules: entries}, "", " ")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to marshal whitelist: %w", err)
+	}
+
+	fn := fmt.Sprintf("%s.%s", fileName, ext)
+	if err := os.WriteFile(fn, content, fs.ModePerm); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", fn, err)
+	}
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Whitelist of %d rules saved to: %s}}::green", time.Now().Format(time.Stamp), count, fn))
+
+	return nil
+}
+
+// ruleEntry converts a loaded rule back into its file form
+func ruleEntry(rule *WhiteListRule) WhiteListEntry {
+	entry := WhiteListEntry{
+		Name:         rule.Name,
+		Vid:          rule.Vid,
+		Pid:          rule.Pid,
+		Serial:       rule.Serial,
+		Manufacturer: rule.Manufacturer,
+		Product:      rule.Product,
+		Host:         rule.Host,
+		Port:         rule.Port,
+		Ignore:       rule.IsIgnore,
+		Block:        rule.Deny,
+		Comment:      rule.Commentary,
+	}
+	if !rule.ValidFrom.IsZero() {
+		entry.ValidFrom = rule.ValidFrom.Format(time.RFC3339)
+	}
+	if !rule.ValidUntil.IsZero() {
+		entry.ValidUntil = rule.ValidUntil.Format(time.RFC3339)
+	}
+	return entry
+}
+
+// entryDescription names an entry in messages
+func entryDescription(entry WhiteListEntry) string {
+	if entry.Name != "" {
+		return entry.Name
+	}
+	if entry.Comment != "" {
+		return entry.Comment
+	}
+	return fmt.Sprintf("vid=%s pid=%s serial=%s", entry.Vid, entry.Pid, entry.Serial)
+}
+
+// whiteListEntries returns one rule per distinct device of events, in the order
+// the devices were first seen
+func whiteListEntries(events []data.Event) []WhiteListEntry {
+	var entries []WhiteListEntry
+	seen := make(map[string]bool)
+
+	for _, event := range events {
+		serial := event.SerialNumber
+		if serial == "None" {
+			serial = ""
+		}
+
+		key := strings.ToLower(event.Vid) + ":" + strings.ToLower(event.Pid) + ":" + serial
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		entries = append(entries, WhiteListEntry{
+			Vid:     strings.ToLower(event.Vid),
+			Pid:     strings.ToLower(event.Pid),
+			Serial:  globEscape(serial),
+			Comment: deviceDescription(event),
+		})
+	}
+
+	return entries
+}
+
+// deviceDescription names a device for a whitelist comment, preferring usb.ids names
+func deviceDescription(event data.Event) string {
+	manufacturer, product := usbids.FindDevice(event.Vid, event.Pid)
+	if manufacturer == "" && event.ManufacturerName != "None" {
+		manufacturer = event.ManufacturerName
+	}
+	if product == "" && event.ProductName != "None" {
+		product = event.ProductName
+	}
+
+	name := strings.TrimSpace(manufacturer + " " + product)
+	if name == "" {
+		name = "Unknown device"
+	}
+	return fmt.Sprintf("%s (%s:%s)", name, strings.ToLower(event.Vid), strings.ToLower(event.Pid))
+}
+
+// udevWhiteList renders entries as udev rules read by udevWhiteListParser. udev
+// rules only allow or ignore a serial number, entries with other criteria are
+// reported and left out. It returns the rules and their number.
+func udevWhiteList(entries []WhiteListEntry) ([]byte, int) {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("# Generated by luft on %s\n", time.Now().Format(time.RFC3339)))
+
+	count := 0
+	for _, entry := range entries {
+		line, err := udevEntryLine(entry)
+		if err != nil {
+			_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: skipping rule %s: %s}}::yellow",
+				time.Now().Format(time.Stamp), entryDescription(entry), err.Error()))
+			continue
+		}
+
+		sb.WriteString(line + "\n")
+		count++
+	}
+
+	return []byte(sb.String()), count
+}
+
+// globEscape escapes the glob characters of a literal value
+func globEscape(value string) string {
+	var sb strings.Builder
+	for _, c := range value {
+		if strings.ContainsRune(`*?[\`, c) {
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
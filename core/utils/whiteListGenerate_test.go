@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUDevWhiteList(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry WhiteListEntry
+		want  string
+	}{
+		{
+			name:  "serial",
+			entry: WhiteListEntry{Serial: "4C530001", Comment: "backup stick"},
+			want:  `ATTRS{serial}=="4C530001",ENV{UDISKS_IGNORE}="0" # backup stick`,
+		},
+		{
+			name:  "ignored serial",
+			entry: WhiteListEntry{Name: "admin key", Serial: "YK*", Ignore: true},
+			want:  `ATTRS{serial}=="YK*",ENV{UDISKS_IGNORE}="1" # admin key`,
+		},
+		// A udev rule would allow the serial everywhere, forever
+		{name: "host", entry: WhiteListEntry{Serial: "X", Host: "ws-01"}},
+		{name: "validity", entry: WhiteListEntry{Serial: "X", ValidUntil: "2025-12-31"}},
+		{name: "vid and pid", entry: WhiteListEntry{Vid: "0781", Pid: "5567", Serial: "X"}},
+		{name: "port", entry: WhiteListEntry{Serial: "X", Port: "1-2"}},
+		{name: "product", entry: WhiteListEntry{Serial: "X", Product: "Cruzer Blade"}},
+		{name: "block", entry: WhiteListEntry{Serial: "X", Block: true}},
+		{name: "no serial", entry: WhiteListEntry{Vid: "0781", Pid: "5567"}},
+		{name: "quote in serial", entry: WhiteListEntry{Serial: `a"b`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, count := udevWhiteList([]WhiteListEntry{tt.entry})
+
+			lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+			rules := lines[1:]
+			if tt.want == "" {
+				if count != 0 || len(rules) != 0 {
+					t.Errorf("wrote %d rules %q, want the entry left out", count, rules)
+				}
+				return
+			}
+			if count != 1 || len(rules) != 1 || rules[0] != tt.want {
+				t.Errorf("wrote %d rules %q, want %q", count, rules, tt.want)
+			}
+		})
+	}
+}
+
+// TestConvertUDevRoundTrip reads the udev rules written for entries back, as
+// whitelist convert and --whitelist do
+func TestConvertUDevRoundTrip(t *testing.T) {
+	entries := []WhiteListEntry{
+		{Serial: "4C530001", Comment: "backup stick"},
+		{Serial: "YK*", Ignore: true, Comment: "admin keys"},
+		{Serial: "X", Host: "ws-01", ValidUntil: "2025-12-31"},
+	}
+	content, count := udevWhiteList(entries)
+	if count != 2 {
+		t.Fatalf("wrote %d rules, want 2", count)
+	}
+
+	rules, warnings, err := udevWhiteListParser("whitelist.rules", content)
+	if err != nil || len(warnings) != 0 {
+		t.Fatalf("udevWhiteListParser: %v %q", err, warnings)
+	}
+	if len(rules) != 2 ||
+		rules[0].Serial != "4C530001" || rules[0].IsIgnore ||
+		rules[1].Serial != "YK*" || !rules[1].IsIgnore {
+		t.Errorf("rules = %+v", rules)
+	}
+}
+
+func TestSerialEntries(t *testing.T) {
+	entries := []WhiteListEntry{
+		{Vid: "0781", Pid: "5567", Serial: "4C530001", Comment: "SanDisk Cruzer Blade (0781:5567)"},
+		{Vid: "046d", Pid: "c31c", Comment: "Logitech Keyboard K120 (046d:c31c)"},
+	}
+
+	got := serialEntries(entries)
+	if len(got) != 1 || got[0] != (WhiteListEntry{Serial: "4C530001", Comment: "SanDisk Cruzer Blade (0781:5567)"}) {
+		t.Errorf("serialEntries = %+v", got)
+	}
+}
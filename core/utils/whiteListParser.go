@@ -9,74 +9,287 @@ import (
 	"time"
 
 	"github.com/i582/cfmt/cmd/cfmt"
+	"github.com/pixfid/luft/data"
 )
 
 var (
 	udevRulesRegex = regexp.MustCompile(`\S+"(?P<serial>.*)"\S+"(?P<flag>.*)"\s#(?P<comment>.*)`)
-	wl             = map[string]*Serial{}
+	wl             []*WhiteListRule
 )
 
-type Serial struct {
-	Serial     string
-	IsIgnore   bool
-	Commentary string
+// WhiteListRule is a whitelist entry. A device matches when it matches every set
+// field; text fields take exact values or globs (*, ?, [...]). A Deny rule, such
+// as a USBGuard block rule, marks the devices it matches untrusted.
+type WhiteListRule struct {
+	Name         string
+	Vid          string
+	Pid          string
+	Serial       string // case-sensitive, like udev ATTRS{serial}
+	Manufacturer string // case-insensitive
+	Product      string // case-insensitive
+	Host         string // case-insensitive
+	Port         string // bus-port path, e.g. 1-2.3
+	ValidFrom    time.Time
+	ValidUntil   time.Time
+	IsIgnore     bool
+	Deny         bool
+	Commentary   string
+
+	matchers []fieldMatcher
+}
+
+// fieldMatcher matches one field of an event against a compiled glob
+type fieldMatcher struct {
+	value   func(data.Event) string
+	pattern *regexp.Regexp
+}
+
+// compile validates the rule and prepares its matchers
+func (r *WhiteListRule) compile() error {
+	fields := []struct {
+		name     string
+		pattern  string
+		foldCase bool
+		value    func(data.Event) string
+	}{
+		{"vid", r.Vid, true, func(e data.Event) string { return e.Vid }},
+		{"pid", r.Pid, true, func(e data.Event) string { return e.Pid }},
+		{"serial", r.Serial, false, func(e data.Event) string { return e.SerialNumber }},
+		{"manufacturer", r.Manufacturer, true, func(e data.Event) string { return e.ManufacturerName }},
+		{"product", r.Product, true, func(e data.Event) string { return e.ProductName }},
+		{"host", r.Host, true, func(e data.Event) string { return e.Host }},
+		{"port", r.Port, false, func(e data.Event) string { return e.ConnectionPort }},
+	}
+
+	r.matchers = nil
+	for _, field := range fields {
+		if field.pattern == "" {
+			continue
+		}
+		pattern, err := globPattern(field.pattern, field.foldCase)
+		if err != nil {
+			return fmt.Errorf("invalid %s pattern %q: %w", field.name, field.pattern, err)
+		}
+		r.matchers = append(r.matchers, fieldMatcher{value: field.value, pattern: pattern})
+	}
+
+	if len(r.matchers) == 0 {
+		return fmt.Errorf("rule matches every device, set at least one of vid, pid, serial, manufacturer, product, host or port")
+	}
+	if !r.ValidFrom.IsZero() && !r.ValidUntil.IsZero() && r.ValidUntil.Before(r.ValidFrom) {
+		return fmt.Errorf("validity period ends before it starts")
+	}
+
+	return nil
+}
+
+// Matches reports whether the rule allows the event
+func (r *WhiteListRule) Matches(event data.Event) bool {
+	if !r.ValidFrom.IsZero() || !r.ValidUntil.IsZero() {
+		// A device without wall-clock time can't be placed in the validity period
+		if event.ConnectedTime.IsZero() {
+			return false
+		}
+		if !r.ValidFrom.IsZero() && event.ConnectedTime.Before(r.ValidFrom) {
+			return false
+		}
+		if !r.ValidUntil.IsZero() && event.ConnectedTime.After(r.ValidUntil) {
+			return false
+		}
+	}
+
+	for _, matcher := range r.matchers {
+		if !matcher.pattern.MatchString(matcher.value(event)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// String describes the rule in reports
+func (r *WhiteListRule) String() string {
+	if r.Name != "" {
+		return r.Name
+	}
+
+	var parts []string
+	for _, field := range []struct{ name, value string }{
+		{"vid", r.Vid},
+		{"pid", r.Pid},
+		{"serial", r.Serial},
+		{"manufacturer", r.Manufacturer},
+		{"product", r.Product},
+		{"host", r.Host},
+		{"port", r.Port},
+	} {
+		if field.value != "" {
+			parts = append(parts, fmt.Sprintf("%s=%s", field.name, field.value))
+		}
+	}
+	if !r.ValidFrom.IsZero() {
+		parts = append(parts, "from="+r.ValidFrom.Format("2006-01-02"))
+	}
+	if !r.ValidUntil.IsZero() {
+		parts = append(parts, "until="+r.ValidUntil.Format("2006-01-02"))
+	}
+	if r.Deny {
+		parts = append(parts, "block")
+	}
+
+	return strings.Join(parts, " ")
 }
 
-func IsInWhiteList(serial string) bool {
-	return wl[serial] != nil
+// globPattern converts a udev style glob into an anchored regexp. Unlike
+// path.Match, * also matches '/', which appears in product strings; a
+// backslash makes the next character literal.
+func globPattern(glob string, foldCase bool) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	if foldCase {
+		sb.WriteString("(?i)")
+	}
+	sb.WriteString("^")
+
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			sb.WriteString(regexp.QuoteMeta(string(glob[i])))
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+// AddWhiteListRule validates a rule and appends it to the loaded whitelist
+func AddWhiteListRule(rule WhiteListRule) error {
+	if err := rule.compile(); err != nil {
+		return err
+	}
+	wl = append(wl, &rule)
+	return nil
 }
 
-func WhiteListSerialInfo(serial string) *Serial {
-	return wl[serial]
+// MatchWhiteList returns the first whitelist rule allowing the event, or nil
+func MatchWhiteList(event data.Event) *WhiteListRule {
+	for _, rule := range wl {
+		if rule.Matches(event) {
+			return rule
+		}
+	}
+	return nil
 }
 
+// LoadWhiteList loads the whitelist rules of a YAML, JSON, udev rules or USBGuard rules file
 func LoadWhiteList(wlPath string) error {
-	content, err := os.ReadFile(wlPath)
+	rules, format, warnings, err := readWhiteList(wlPath)
+	for _, warning := range warnings {
+		_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: %s}}::yellow", time.Now().Format(time.Stamp), warning))
+	}
 	if err != nil {
 		return err
 	}
 
-	return udevWhiteListParser(content)
+	for _, rule := range rules {
+		if err := AddWhiteListRule(rule); err != nil {
+			return err
+		}
+	}
+
+	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Loaded %d whitelist rules (%s)}}::green", time.Now().Format(time.Stamp), len(rules), format))
+	return nil
 }
 
-func emitSerial(wl map[string]*Serial, serial Serial) {
-	wl[serial.Serial] = &serial
+// readWhiteList parses a whitelist file without loading it. It returns the rules,
+// the file format and the problems that made lines be skipped.
+func readWhiteList(wlPath string) ([]WhiteListRule, string, []string, error) {
+	content, err := os.ReadFile(wlPath)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var rules []WhiteListRule
+	var warnings []string
+	format := whiteListFormat(wlPath, content)
+	switch format {
+	case "udev":
+		rules, warnings, err = udevWhiteListParser(wlPath, content)
+	case "usbguard":
+		rules, warnings, err = usbguardWhiteListParser(wlPath, content)
+	default:
+		rules, err = parseWhiteListFile(wlPath, content, format)
+	}
+
+	return rules, format, warnings, err
 }
 
-func udevWhiteListParser(fileData []byte) error {
-	content := string(fileData)
+// udevRule parses a udev rules line into a serial rule, with a warning for a
+// flag that is not a boolean
+func udevRule(line string) (WhiteListRule, string, error) {
+	fields := udevRulesRegex.FindStringSubmatch(line)
+	if len(fields) < 4 {
+		return WhiteListRule{}, "", fmt.Errorf("unrecognized whitelist rule: %s", line)
+	}
 
-	re := udevRulesRegex.FindAllStringSubmatch(content, -1)
+	var warning string
+	result, err := strconv.ParseBool(fields[2])
+	if err != nil {
+		warning = fmt.Sprintf("invalid boolean value %q (serial: %s), defaulting to false", fields[2], fields[1])
+		result = false
+	}
 
-	if re == nil || len(re) == 0 {
-		return fmt.Errorf("no valid whitelist entries found in file")
+	// udev compares ATTRS{serial} with glob semantics, so do we
+	rule := WhiteListRule{
+		Serial:     fields[1],
+		IsIgnore:   result,
+		Commentary: strings.TrimSpace(fields[3]),
 	}
+	return rule, warning, rule.compile()
+}
 
-	successCount := 0
-	for i, fields := range re {
-		if len(fields) < 4 {
-			_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: skipping malformed whitelist entry %d}}::yellow", time.Now().Format(time.Stamp), i+1))
+func udevWhiteListParser(wlPath string, fileData []byte) ([]WhiteListRule, []string, error) {
+	var rules []WhiteListRule
+	var warnings []string
+
+	for i, line := range strings.Split(string(fileData), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
 
-		result, err := strconv.ParseBool(fields[2])
+		rule, warning, err := udevRule(line)
+		if warning != "" {
+			warnings = append(warnings, fmt.Sprintf("%s:%d: %s", wlPath, i+1, warning))
+		}
 		if err != nil {
-			_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: invalid boolean value in whitelist entry %d (serial: %s), defaulting to false}}::yellow", time.Now().Format(time.Stamp), i+1, fields[1]))
-			result = false
+			warnings = append(warnings, fmt.Sprintf("%s:%d: skipping %s", wlPath, i+1, err.Error()))
+			continue
 		}
-
-		emitSerial(wl, Serial{
-			Serial:     fields[1],
-			IsIgnore:   result,
-			Commentary: strings.TrimSpace(fields[3]),
-		})
-		successCount++
+		rules = append(rules, rule)
 	}
 
-	if successCount == 0 {
-		return fmt.Errorf("failed to parse any valid whitelist entries")
+	if len(rules) == 0 {
+		return nil, warnings, fmt.Errorf("no valid whitelist entries found in file")
 	}
-
-	_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Loaded %d whitelist entries}}::green", time.Now().Format(time.Stamp), successCount))
-	return nil
+	return rules, warnings, nil
 }
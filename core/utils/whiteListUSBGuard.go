@@ -0,0 +1,404 @@
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/i582/cfmt/cmd/cfmt"
+	"github.com/thoas/go-funk"
+)
+
+// USBGuard rule syntax, see usbguard-rules.conf(5):
+//
+//	allow id 0781:5567 serial "4C530001230921115325" with-interface 08:06:50
+//	block id 16d0:* via-port one-of { "1-2" "1-3" }
+var (
+	usbguardTargets = map[string]bool{
+		"allow":  true,
+		"block":  true,
+		"reject": true,
+	}
+	usbguardOperators = map[string]bool{
+		"all-of":         true,
+		"one-of":         true,
+		"none-of":        true,
+		"equals":         true,
+		"equals-ordered": true,
+		"match-all":      true,
+	}
+	// usbguardUnchecked are attributes luft can't verify from logs
+	usbguardUnchecked = map[string]bool{
+		"hash":              true,
+		"parent-hash":       true,
+		"with-interface":    true,
+		"with-connect-type": true,
+		"label":             true,
+		"if":                true,
+	}
+	usbguardRuleRegex = regexp.MustCompile(`^(allow|block|reject)(\s|$)`)
+	usbguardIDRegex   = regexp.MustCompile(`^([0-9a-fA-F]{4}|\*):([0-9a-fA-F]{4}|\*)$`)
+)
+
+// usbguardAttribute is one attribute of a USBGuard rule, e.g. serial one-of { "a" "b" }
+type usbguardAttribute struct {
+	name     string
+	operator string
+	values   []string
+}
+
+// isUSBGuardPolicy reports whether content looks like a USBGuard rules file
+func isUSBGuardPolicy(content []byte) bool {
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return usbguardRuleRegex.MatchString(line)
+	}
+	return false
+}
+
+// usbguardTokens splits a rule into words, quoted strings and braces
+func usbguardTokens(line string) ([]string, error) {
+	var tokens []string
+
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '{' || c == '}':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			var sb strings.Builder
+			sb.WriteByte('"')
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				sb.WriteByte(line[i])
+			}
+			if i >= len(line) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, sb.String())
+			i++
+		default:
+			start := i
+			for i < len(line) && !strings.ContainsRune(" \t{}\"", rune(line[i])) {
+				i++
+			}
+			tokens = append(tokens, line[start:i])
+		}
+	}
+
+	return tokens, nil
+}
+
+// unquote returns the value of a token, strings lose their opening quote mark
+func unquote(token string) string {
+	return strings.TrimPrefix(token, `"`)
+}
+
+// parseUSBGuardLine parses a rule into its target and attributes
+func parseUSBGuardLine(line string) (string, []usbguardAttribute, error) {
+	tokens, err := usbguardTokens(line)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(tokens) == 0 || !usbguardTargets[tokens[0]] {
+		return "", nil, fmt.Errorf("rule must start with allow, block or reject")
+	}
+	target := tokens[0]
+
+	var attributes []usbguardAttribute
+	for i := 1; i < len(tokens); {
+		attribute := usbguardAttribute{name: tokens[i]}
+		i++
+
+		// Conditions take the rest of the rule
+		if attribute.name == "if" {
+			attributes = append(attributes, attribute)
+			break
+		}
+		if strings.HasPrefix(attribute.name, `"`) || attribute.name == "{" || attribute.name == "}" {
+			return "", nil, fmt.Errorf("expected an attribute name, got %s", attribute.name)
+		}
+
+		if i < len(tokens) && usbguardOperators[tokens[i]] {
+			attribute.operator = tokens[i]
+			i++
+		}
+		if i >= len(tokens) {
+			return "", nil, fmt.Errorf("missing value of %s", attribute.name)
+		}
+
+		if tokens[i] == "{" {
+			i++
+			for i < len(tokens) && tokens[i] != "}" {
+				attribute.values = append(attribute.values, unquote(tokens[i]))
+				i++
+			}
+			if i >= len(tokens) {
+				return "", nil, fmt.Errorf("unterminated set of %s", attribute.name)
+			}
+			i++
+		} else {
+			attribute.values = append(attribute.values, unquote(tokens[i]))
+			i++
+		}
+
+		attributes = append(attributes, attribute)
+	}
+
+	return target, attributes, nil
+}
+
+// usbguardRules converts a USBGuard rule into whitelist rules, one per combination
+// of the values of one-of sets. Attributes luft can't check are returned as ignored
+// for block and reject rules, which only get broader without them. An allow rule
+// without them would trust devices the policy doesn't, e.g. a HID impostor reusing
+// the id of an allowed storage device, so it is rejected.
+func usbguardRules(target string, attributes []usbguardAttribute) ([]WhiteListRule, []string, error) {
+	rules := []WhiteListRule{{Deny: target != "allow"}}
+	var ignored []string
+
+	for _, attribute := range attributes {
+		if usbguardUnchecked[attribute.name] {
+			ignored = append(ignored, attribute.name)
+		}
+	}
+	if target == "allow" && len(ignored) > 0 {
+		return nil, nil, fmt.Errorf("allow rule with %s, which can't be verified from logs, would trust every device matching the rest of it",
+			strings.Join(funk.UniqString(ignored), ", "))
+	}
+
+	for _, attribute := range attributes {
+		if usbguardUnchecked[attribute.name] {
+			continue
+		}
+
+		values := attribute.values
+		switch attribute.operator {
+		case "none-of":
+			return nil, nil, fmt.Errorf("%s none-of can't be expressed as a whitelist rule", attribute.name)
+		case "", "one-of":
+		default:
+			// A device has one value of these attributes, a set of several never matches it
+			if len(funk.UniqString(values)) > 1 {
+				return nil, nil, fmt.Errorf("%s %s with several values never matches a device", attribute.name, attribute.operator)
+			}
+		}
+		if len(values) > 1 && attribute.operator == "" {
+			return nil, nil, fmt.Errorf("set of %s needs an operator", attribute.name)
+		}
+
+		var set func(rule *WhiteListRule, value string) error
+		switch attribute.name {
+		case "id":
+			set = func(rule *WhiteListRule, value string) error {
+				m := usbguardIDRegex.FindStringSubmatch(value)
+				if m == nil {
+					return fmt.Errorf("invalid device id %q", value)
+				}
+				rule.Vid, rule.Pid = strings.TrimPrefix(m[1], "*"), strings.TrimPrefix(m[2], "*")
+				return nil
+			}
+		case "serial":
+			set = func(rule *WhiteListRule, value string) error { rule.Serial = globEscape(value); return nil }
+		case "name":
+			set = func(rule *WhiteListRule, value string) error { rule.Product = globEscape(value); return nil }
+		case "via-port":
+			set = func(rule *WhiteListRule, value string) error { rule.Port = globEscape(value); return nil }
+		default:
+			return nil, nil, fmt.Errorf("unknown attribute %s", attribute.name)
+		}
+
+		var expanded []WhiteListRule
+		for _, rule := range rules {
+			for _, value := range funk.UniqString(values) {
+				next := rule
+				if err := set(&next, value); err != nil {
+					return nil, nil, err
+				}
+				expanded = append(expanded, next)
+			}
+		}
+		rules = expanded
+	}
+
+	return rules, ignored, nil
+}
+
+// usbguardWhiteListParser parses the rules of a USBGuard rules.conf. Rules keep
+// their order, so as in USBGuard the first rule matching a device decides.
+func usbguardWhiteListParser(wlPath string, fileData []byte) ([]WhiteListRule, []string, error) {
+	var rules []WhiteListRule
+	var warnings []string
+
+	for i, line := range strings.Split(string(fileData), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		lineRules, ignored, err := usbguardLineRules(fmt.Sprintf("%s:%d", filepath.Base(wlPath), i+1), line)
+		if len(ignored) > 0 {
+			warnings = append(warnings, fmt.Sprintf("%s:%d: not checking %s, luft can't verify them from logs",
+				wlPath, i+1, strings.Join(ignored, ", ")))
+		}
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s:%d: skipping USBGuard rule: %s", wlPath, i+1, err.Error()))
+			continue
+		}
+		rules = append(rules, lineRules...)
+	}
+
+	if len(rules) == 0 {
+		return nil, warnings, fmt.Errorf("no valid USBGuard rules found in file")
+	}
+	return rules, warnings, nil
+}
+
+// usbguardLineRules returns the whitelist rules of a USBGuard rule found at position,
+// and the attributes left unchecked
+func usbguardLineRules(position string, line string) ([]WhiteListRule, []string, error) {
+	target, attributes, err := parseUSBGuardLine(line)
+	if err != nil {
+		return nil, nil, err
+	}
+	rules, ignored, err := usbguardRules(target, attributes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var compiled []WhiteListRule
+	for _, rule := range rules {
+		rule.Name = position + " " + target
+		rule.Commentary = line
+		if err := rule.compile(); err != nil {
+			if rule.Deny {
+				// A catch-all block rule only restates that unlisted devices are untrusted
+				continue
+			}
+			return nil, funk.UniqString(ignored), err
+		}
+		compiled = append(compiled, rule)
+	}
+
+	return compiled, funk.UniqString(ignored), nil
+}
+
+// usbguardString quotes a value for a USBGuard rule
+func usbguardString(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// globUnescape returns the literal value of a glob, or false when it has wildcards
+func globUnescape(glob string) (string, bool) {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*', '?', '[':
+			return "", false
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			sb.WriteByte(glob[i])
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), true
+}
+
+// usbguardRule renders an entry as a USBGuard rule, or explains why it can't be
+func usbguardRule(entry WhiteListEntry) (string, error) {
+	var unsupported []string
+	for _, field := range []struct{ name, value string }{
+		{"manufacturer", entry.Manufacturer},
+		{"host", entry.Host},
+		{"valid_from", entry.ValidFrom},
+		{"valid_until", entry.ValidUntil},
+	} {
+		if field.value != "" {
+			unsupported = append(unsupported, field.name)
+		}
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return "", fmt.Errorf("USBGuard rules can't match %s", strings.Join(unsupported, ", "))
+	}
+
+	target := "allow"
+	if entry.Block {
+		target = "block"
+	}
+	parts := []string{target}
+
+	if entry.Vid != "" || entry.Pid != "" {
+		id := fmt.Sprintf("%s:%s", usbguardIDPart(entry.Vid), usbguardIDPart(entry.Pid))
+		if !usbguardIDRegex.MatchString(id) {
+			return "", fmt.Errorf("USBGuard ids take 4 hex digits or *, got %s", id)
+		}
+		parts = append(parts, "id", strings.ToLower(id))
+	}
+
+	for _, field := range []struct{ attribute, value string }{
+		{"serial", entry.Serial},
+		{"name", entry.Product},
+		{"via-port", entry.Port},
+	} {
+		if field.value == "" {
+			continue
+		}
+		value, ok := globUnescape(field.value)
+		if !ok {
+			return "", fmt.Errorf("USBGuard %s must be exact, got pattern %q", field.attribute, field.value)
+		}
+		parts = append(parts, field.attribute, usbguardString(value))
+	}
+
+	if len(parts) == 1 {
+		return "", fmt.Errorf("rule matches every device")
+	}
+	return strings.Join(parts, " "), nil
+}
+
+func usbguardIDPart(value string) string {
+	if value == "" {
+		return "*"
+	}
+	return value
+}
+
+// usbguardWhiteList renders entries as a USBGuard rules file, with the entry
+// comments above their rules. It returns the rules and their number.
+func usbguardWhiteList(entries []WhiteListEntry) ([]byte, int) {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("# Generated by luft on %s\n", time.Now().Format(time.RFC3339)))
+
+	count := 0
+	for _, entry := range entries {
+		rule, err := usbguardRule(entry)
+		if err != nil {
+			_, _ = cfmt.Println(cfmt.Sprintf("{{[%v] Warning: skipping rule %s: %s}}::yellow",
+				time.Now().Format(time.Stamp), entryDescription(entry), err.Error()))
+			continue
+		}
+
+		if entry.Comment != "" {
+			sb.WriteString("# " + strings.ReplaceAll(entry.Comment, "\n", " ") + "\n")
+		}
+		sb.WriteString(rule + "\n")
+		count++
+	}
+
+	return []byte(sb.String()), count
+}
@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pixfid/luft/data"
+)
+
+func TestUSBGuardRules(t *testing.T) {
+	// rule is the part of a WhiteListRule a USBGuard rule sets
+	type rule struct {
+		vid, pid, serial, product, port string
+		deny                            bool
+	}
+
+	tests := []struct {
+		line    string
+		rules   []rule
+		ignored []string
+		err     string
+	}{
+		{
+			line:  `allow id 0781:5567`,
+			rules: []rule{{vid: "0781", pid: "5567"}},
+		},
+		{
+			line:  `allow id 0781:5567 serial "4C530001230921115325" name "Cruzer Blade" via-port "1-2"`,
+			rules: []rule{{vid: "0781", pid: "5567", serial: "4C530001230921115325", product: "Cruzer Blade", port: "1-2"}},
+		},
+		{
+			line:  `allow id 0781:* serial "a*b?"`,
+			rules: []rule{{vid: "0781", serial: `a\*b\?`}},
+		},
+		{
+			line: `allow id one-of { 0781:5567 0951:1666 } serial one-of { "A" "B" "A" }`,
+			rules: []rule{
+				{vid: "0781", pid: "5567", serial: "A"},
+				{vid: "0781", pid: "5567", serial: "B"},
+				{vid: "0951", pid: "1666", serial: "A"},
+				{vid: "0951", pid: "1666", serial: "B"},
+			},
+		},
+		{
+			line:  `allow serial equals { "A" "A" }`,
+			rules: []rule{{serial: "A"}},
+		},
+		{
+			line:  `block id 16d0:* via-port one-of { "1-2" "1-3" }`,
+			rules: []rule{{vid: "16d0", port: "1-2", deny: true}, {vid: "16d0", port: "1-3", deny: true}},
+		},
+		{
+			line:    `reject id 046d:c31c with-interface 03:01:01 hash "abc="`,
+			rules:   []rule{{vid: "046d", pid: "c31c", deny: true}},
+			ignored: []string{"with-interface", "hash"},
+		},
+		{
+			line:    `block with-interface equals { 03:*:* }`,
+			rules:   []rule{{deny: true}},
+			ignored: []string{"with-interface"},
+		},
+		// Allow rules can't lose attributes: the storage stick rule would trust a
+		// keyboard with the same id
+		{line: `allow id 0781:5567 with-interface 08:06:50`, err: "with-interface"},
+		{line: `allow id 0781:5567 serial "A" hash "abc=" parent-hash "def="`, err: "hash, parent-hash"},
+		{line: `allow id 0781:5567 with-connect-type "hotplug"`, err: "with-connect-type"},
+		{line: `allow id 0781:5567 if !rule-applied`, err: "if"},
+		{line: `allow serial none-of { "A" }`, err: "none-of"},
+		{line: `allow serial all-of { "A" "B" }`, err: "never matches"},
+		{line: `allow serial { "A" "B" }`, err: "needs an operator"},
+		{line: `allow id 0781`, err: "invalid device id"},
+		{line: `allow color "red"`, err: "unknown attribute"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			target, attributes, err := parseUSBGuardLine(tt.line)
+			if err != nil {
+				t.Fatalf("parseUSBGuardLine: %v", err)
+			}
+
+			rules, ignored, err := usbguardRules(target, attributes)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.err)
+				}
+				if rules != nil {
+					t.Fatalf("rules = %+v, want none", rules)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var got []rule
+			for _, r := range rules {
+				got = append(got, rule{r.Vid, r.Pid, r.Serial, r.Product, r.Port, r.Deny})
+			}
+			if !reflect.DeepEqual(got, tt.rules) {
+				t.Errorf("rules = %+v, want %+v", got, tt.rules)
+			}
+			if !reflect.DeepEqual(ignored, tt.ignored) {
+				t.Errorf("ignored = %v, want %v", ignored, tt.ignored)
+			}
+		})
+	}
+}
+
+// TestUSBGuardImportSkipsUncheckedAllow imports a policy allowing a storage
+// stick only with its mass storage interface, and checks that a keyboard
+// reusing its id is not trusted
+func TestUSBGuardImportSkipsUncheckedAllow(t *testing.T) {
+	policy := strings.Join([]string{
+		`allow id 0781:5567 serial "4C530001230921115325" with-interface 08:06:50`,
+		`allow id 0951:1666 serial "K"`,
+		`block id 16d0:* with-interface 03:*:*`,
+	}, "\n")
+	path := filepath.Join(t.TempDir(), "rules.conf")
+	if err := os.WriteFile(path, []byte(policy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, format, warnings, err := readWhiteList(path)
+	if err != nil {
+		t.Fatalf("readWhiteList: %v", err)
+	}
+	if format != "usbguard" {
+		t.Fatalf("format = %q, want usbguard", format)
+	}
+
+	if len(rules) != 2 || rules[0].Vid != "0951" || !rules[1].Deny {
+		t.Fatalf("rules = %+v, want the 0951:1666 allow rule and the 16d0 block rule", rules)
+	}
+	if len(warnings) != 2 ||
+		!strings.Contains(warnings[0], "rules.conf:1: skipping USBGuard rule") || !strings.Contains(warnings[0], "with-interface") ||
+		!strings.Contains(warnings[1], "rules.conf:3: not checking with-interface") {
+		t.Errorf("warnings = %q", warnings)
+	}
+
+	impostor := data.Event{Vid: "0781", Pid: "5567", SerialNumber: "4C530001230921115325"}
+	for _, r := range rules {
+		if r.Matches(impostor) {
+			t.Errorf("rule %s matches a device the policy only allows with its storage interface", r.Name)
+		}
+	}
+}
@@ -11,34 +11,108 @@ const (
 	Connected ActionType = iota
 	Disconnected
 	Unknown
+	Login  // user session opened
+	Logout // user session closed
+	Boot   // system start, ends all sessions of the host
 )
 
 type LogEvent struct {
 	Date       time.Time
 	ActionType ActionType
 	LogLine    string
+	Source     string // file the line was read from, archive:member for archive members
+	Line       int    // line number in Source, the entry seqnum for journal files
+	Offset     int64  // byte offset of the line in the decompressed Source, of the entry object for journal files
+	Host       string
+	Monotonic  time.Duration // kernel [ seconds.micros] stamp, time since boot
+}
+
+// Evidence is a log line an event was built from, with where it was read
+type Evidence struct {
+	Source  string
+	Line    int
+	Offset  int64
+	LogLine string
+}
+
+// Interface is a USB interface of a device with the driver bound to it
+type Interface struct {
+	Number string // bInterfaceNumber from the 1-1:1.N path
+	Driver string
+	Class  string
+}
+
+// Volume is a partition or whole-disk filesystem of a storage device seen in the logs
+type Volume struct {
+	Device     string // block device, e.g. sdb1
+	Filesystem string
+	Label      string
+	MountPoint string
 }
 
 type Event struct {
-	ConnectedTime     time.Time
-	Host              string
-	Vid               string
-	Pid               string
-	ProductName       string
-	ManufacturerName  string
-	SerialNumber      string
-	ConnectionPort    string
-	DisconnectionTime time.Time
-	Trusted           bool
-	IsMassStorage     bool
+	ID                 string // stable identifier of the device session, see utils.EventID
+	ConnectedTime      time.Time
+	Host               string
+	ScanHost           string // configured host a multi-host scan read the event from
+	Vid                string
+	Pid                string
+	ProductName        string
+	ManufacturerName   string
+	SerialNumber       string
+	ConnectionPort     string
+	DisconnectionTime  time.Time     // zero while SessionOpen
+	SessionOpen        bool          // no disconnect logged: still connected or logs missing
+	DisconnectInferred bool          // no disconnect logged, the port enumerated another device at DisconnectionTime
+	Duration           time.Duration // time between connect and disconnect
+	Trusted            bool
+	WhiteListRule      string // whitelist rule the device matched
+	WhiteListComment   string // comment of that rule
+	Ignored            bool   // matched rule is marked ignore
+	IsMassStorage      bool
+	Monotonic          time.Duration
+	Speed              string // low-speed, full-speed, high-speed, SuperSpeed...
+	DeviceNumber       string
+	BcdDevice          string
+	Controller         string // host controller driver, e.g. xhci_hcd
+	Interfaces         []Interface
+	SCSIHost           string   // SCSI host number of the usb-storage/uas binding
+	BlockDevice        string   // sdX name of the disk
+	Capacity           string   // e.g. 15.5 GB
+	Partitions         []string // partition table, e.g. sdb1 sdb2
+	Volumes            []Volume
+	Users              []string   // users with an active session at ConnectedTime
+	FailedLogins       []string   // users with failed logins around ConnectedTime
+	Evidence           []Evidence `json:",omitempty" xml:",omitempty"` // log lines the event was built from, in time order
+}
+
+// Session is a user login session from wtmp, logind or PAM, or a failed login from btmp
+type Session struct {
+	User   string
+	TTY    string // tty, logind session or PAM service
+	From   string // remote host of network logins
+	Host   string // empty when the record does not name the host (wtmp)
+	Start  time.Time
+	End    time.Time // zero while the session is open or its end was not logged
+	Failed bool
 }
 
 type ParseParams struct {
 	Ctx                context.Context
+	Source             string
 	LogPath            string
+	RemoteLogPath      string   // log directory on remote hosts, /var/log when empty
+	LogInclude         []string // globs of the log files to parse, the default log files when empty
+	LogExclude         []string // globs of the log files and directories to skip
+	DryRun             bool     // list the log files that would be parsed instead of parsing them
+	Evidence           bool     // include the evidence lines of the events in exports
+	Explain            string   // ID (or ID prefix) of the event to print the evidence lines of
 	WlPath             string
 	OnlyMass           bool
 	CheckWl            bool
+	ShowIgnored        bool      // keep devices matched by ignore rules of the whitelist
+	Since              time.Time // keep devices connected at or after Since
+	Until              time.Time // keep devices connected at or before Until
 	Export             bool
 	Format             string
 	FileName           string
@@ -55,4 +129,45 @@ type ParseParams struct {
 	InsecureSSH        bool
 	Workers            int
 	Streaming          bool
+	ReferenceDate      time.Time
+	DBPath             string
+	SaveToDB           bool
+	Analysis           string         // analysis to run on the collected events instead of listing them
+	AnalysisWindow     time.Duration  // re-enumeration window of the badusb analysis
+	Generate           string         // whitelist format to generate from the collected events instead of listing them
+	JumpHosts          []JumpHost     // SSH servers the remote host is reached through, in order
+	Hosts              []RemoteTarget // hosts of a multi-host remote scan, replacing the single host fields
+	HostWorkers        int            // hosts of a multi-host scan connected to at once
+}
+
+// RemoteTarget is a host of a multi-host remote scan with its connection settings
+type RemoteTarget struct {
+	Name        string
+	IP          string
+	Port        string
+	Login       string
+	Password    string
+	SSHKeyPath  string
+	SSHTimeout  int
+	InsecureSSH bool
+	LogPath     string
+	JumpHosts   []JumpHost
+}
+
+// JumpHost is an SSH server a remote host is reached through, with its own
+// credentials and host key verification
+type JumpHost struct {
+	Host        string // address or ~/.ssh/config Host alias
+	Port        string
+	Login       string
+	Password    string
+	SSHKeyPath  string
+	InsecureSSH bool
+}
+
+// Finding is a device flagged by an analysis heuristic
+type Finding struct {
+	Event  Event
+	Rule   string
+	Reason string
 }
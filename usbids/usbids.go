@@ -181,6 +181,28 @@ func FindDevice(vid, pid string) (string, string) {
 	return "", ""
 }
 
+// IsKnownVendor reports whether vid is listed in the USB IDs database
+func IsKnownVendor(vid string) bool {
+	_, ok := vendors[strings.ToLower(vid)]
+	return ok
+}
+
+// IsKeyboardVendor reports whether the vendor lists a keyboard among its products
+func IsKeyboardVendor(vid string) bool {
+	vendor := vendors[strings.ToLower(vid)]
+	if vendor == nil {
+		return false
+	}
+
+	for _, product := range vendor.Product {
+		if strings.Contains(strings.ToLower(product.Name), "keyboard") {
+			return true
+		}
+	}
+
+	return false
+}
+
 // getCachePath returns the cache file path for a given USB IDs file
 func getCachePath(sourcePath string) string {
 	return sourcePath + ".cache"